@@ -1,4 +1,4 @@
-package main
+package sampledata
 
 import "github.com/hankgalt/starbucks/pkg/listing"
 
@@ -1,29 +1,182 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/hankgalt/starbucks/pkg/config"
-	"github.com/hankgalt/starbucks/pkg/constants"
+	"github.com/hankgalt/starbucks/pkg/geocoder"
 	"github.com/hankgalt/starbucks/pkg/listing"
 	"github.com/hankgalt/starbucks/pkg/logging"
 	"github.com/hankgalt/starbucks/pkg/server"
+	"github.com/hankgalt/starbucks/pkg/storage"
+	"github.com/hankgalt/starbucks/pkg/tracing"
 	"go.uber.org/zap"
 )
 
 func main() {
-	logging.InitializeLogger()
-
-	config, err := config.GetConfig()
+	config, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		logging.Logger.Error("unable to setup config", zap.Error(err))
+		fmt.Fprintln(os.Stderr, "unable to setup config:", err)
+		os.Exit(2)
+	}
+	if config.PrintConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "unable to print config:", err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		os.Exit(1)
+	}
+	if config.PrintConfig {
 		return
 	}
-	gateway := listing.NewJasonGateway(config, logging.Logger)
-	gateway.ProcessFile()
 
-	srv := server.NewHTTPServer(fmt.Sprintf(":%d", constants.SERVICE_PORT), gateway, logging.Logger)
-	logging.Logger.Info("listening for store requests", zap.Int("port", constants.SERVICE_PORT))
-	log.Fatal(srv.ListenAndServe())
+	if err := logging.InitializeLogger(config.LogLevel, config.LogOutputs); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to setup logging:", err)
+		os.Exit(1)
+	}
+
+	// run returns before exiting so its deferred cleanups run and logs are flushed
+	err = run(config)
+	if err != nil {
+		logging.Logger.Error("store server failed", zap.Error(err))
+	}
+	if err := logging.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "error closing log file:", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// run serves store requests until SIGINT or SIGTERM, returning an error when the
+// server can't be started or stops serving on its own
+func run(config *config.Configuration) error {
+	// cancelled on SIGINT or SIGTERM to start shutting down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, config, logging.Logger)
+	if err != nil {
+		return fmt.Errorf("unable to setup tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logging.Logger.Error("error flushing traces", zap.Error(err))
+		}
+	}()
+
+	gc, err := geocoder.New(config, logging.Logger)
+	if err != nil {
+		return fmt.Errorf("unable to setup geocoder: %w", err)
+	}
+	if c, ok := gc.(io.Closer); ok {
+		// flushes the geocode cache file
+		defer func() {
+			if err := c.Close(); err != nil {
+				logging.Logger.Error("error closing geocoder", zap.Error(err))
+			}
+		}()
+	}
+	var st listing.Storage
+	if config.StorageDir != "" {
+		fs, err := storage.NewFileStorage(config.StorageDir, logging.Logger)
+		if err != nil {
+			return fmt.Errorf("unable to open storage %s: %w", config.StorageDir, err)
+		}
+		defer func() {
+			if err := fs.Close(); err != nil {
+				logging.Logger.Error("error closing storage", zap.Error(err))
+			}
+		}()
+		st = fs
+	}
+	gateway := listing.NewJasonGateway(config, gc, st, logging.Logger)
+	defer gateway.Close()
+
+	// reload store data on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if err := gateway.Reload(); err != nil {
+				logging.Logger.Error("unable to reload store data", zap.Error(err))
+			}
+		}
+	}()
+
+	if config.ReloadInterval != "" {
+		interval, err := time.ParseDuration(config.ReloadInterval)
+		if err != nil {
+			return fmt.Errorf("invalid reload interval %q: %w", config.ReloadInterval, err)
+		}
+		go gateway.WatchDataFiles(ctx, interval)
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", config.GRPCPort))
+	if err != nil {
+		return fmt.Errorf("unable to listen for grpc requests on port %d: %w", config.GRPCPort, err)
+	}
+	// either server failing stops both
+	srvErr := make(chan error, 2)
+	gsrv := server.NewGRPCServer(gateway, config, logging.Logger)
+	go func() {
+		logging.Logger.Info("listening for grpc store requests", zap.Int("port", config.GRPCPort))
+		if err := gsrv.Serve(l); err != nil {
+			srvErr <- fmt.Errorf("grpc server error: %w", err)
+		}
+	}()
+
+	srv := server.NewHTTPServer(fmt.Sprintf(":%d", config.Port), gateway, config, logging.Logger)
+	go func() {
+		logging.Logger.Info("listening for store requests", zap.Int("port", config.Port))
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			srvErr <- fmt.Errorf("http server error: %w", err)
+		}
+	}()
+
+	// load store data once listening, searches are answered with 503 and
+	// readiness probes fail until it's loaded
+	go func() {
+		if err := gateway.ProcessFile(ctx); err != nil {
+			logging.Logger.Error("unable to load store data", zap.Error(err))
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		logging.Logger.Info("shutting down", zap.String("shutdownTimeout", config.ShutdownTimeout))
+	case err = <-srvErr:
+		logging.Logger.Error("server error, shutting down", zap.Error(err))
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeoutDuration())
+	defer cancel()
+	go func() {
+		<-shutdownCtx.Done()
+		// stop waiting on rpcs once drain time is up
+		gsrv.Stop()
+	}()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logging.Logger.Error("error draining http requests", zap.Error(err))
+	}
+	gsrv.GracefulStop()
+	logging.Logger.Info("stopped serving store requests")
+	return err
 }
@@ -3,36 +3,472 @@ package config
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"net/url"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hankgalt/starbucks/pkg/constants"
+	"github.com/hankgalt/starbucks/pkg/loader"
 	"github.com/hankgalt/starbucks/pkg/logging"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+const DEFAULT_CONFIG_FILE = "config.json"
+const DEFAULT_DATA_DIR = "sample-data"
+const DEFAULT_DATA_FILE = "locations.json"
+const DEFAULT_LOG_LEVEL = "debug"
+const DEFAULT_MAX_REQUEST_BYTES = 1 << 20
+const DEFAULT_MAX_NEAREST_COUNT = 100
+
+// DEFAULT_LOG_OUTPUTS logs to console and appends json lines to text.log
+var DEFAULT_LOG_OUTPUTS = []string{"stdout", "text.log"}
+
+// CONFIG_FILE_ENV sets the config file. Other settings are overridden by
+// environment variables named after their json key, e.g. STARBUCKS_DATA_FILES
+// for data_files, and by flags with dashes for underscores, e.g. -data-files
+const CONFIG_FILE_ENV = "STARBUCKS_CONFIG"
+const ENV_PREFIX = "STARBUCKS_"
+
+// REDACTED replaces secrets in printed configuration
+const REDACTED = "[REDACTED]"
+
 type Configuration struct {
+	// Port is the http port, defaults to 8080
+	Port int `json:"port"`
+	// GRPCPort is the grpc port, defaults to 9090
+	GRPCPort int `json:"grpc_port"`
+
+	// GEOCODER_API_KEY is the google geocoding api key, prefer setting it through env or GeocoderAPIKeyFile
 	GEOCODER_API_KEY string `json:"geocoder_api_key"`
+	// GeocoderAPIKeyFile is a secret file holding the api key, re-read when the key is rotated
+	GeocoderAPIKeyFile string `json:"geocoder_api_key_file"`
+	// GeocoderProvider is "google" or "postal_file", defaults to google when an api key is set, none otherwise
+	GeocoderProvider string `json:"geocoder_provider"`
+	GeocoderCountry  string `json:"geocoder_country"`
+	PostalCodeFile   string `json:"postal_code_file"`
+
+	GeocoderCacheSize int    `json:"geocoder_cache_size"`
+	GeocoderCacheTTL  string `json:"geocoder_cache_ttl"`
+	GeocoderCacheFile string `json:"geocoder_cache_file"`
+
+	// DataDir is the directory relative data files are read from
+	DataDir string `json:"data_dir"`
+	// DataFiles are the store data files to load, absolute or relative to DataDir
+	DataFiles []string `json:"data_files"`
+	// DataFormat is the format of all data files, inferred from each file's extension when empty
+	DataFormat string `json:"data_format"`
+	// CSVMapping maps csv data file headers to store fields, e.g. {"Store ID": "store_id"}
+	CSVMapping map[string]string `json:"csv_mapping"`
+	// GeoJSONMapping maps geojson feature properties to store fields, e.g. {"title": "name"}
+	GeoJSONMapping map[string]string `json:"geojson_mapping"`
+	// ReloadInterval is how often data files are checked for changes, e.g. "30s", empty disables watching
+	ReloadInterval string `json:"reload_interval"`
+	// RequestTimeout bounds the time spent serving an http request, e.g. "10s", defaults to 30s, "0s" disables it
+	RequestTimeout string `json:"request_timeout"`
+	// ShutdownTimeout bounds the time in-flight requests are given to finish on shutdown, e.g. "20s", defaults to 15s
+	ShutdownTimeout string `json:"shutdown_timeout"`
+	// TraceExporter is where trace spans are exported to, "stdout", "file" or "otlp", empty disables tracing
+	TraceExporter string `json:"trace_exporter"`
+	// TraceFile is the file spans are appended to as OTLP JSON lines with the file exporter
+	TraceFile string `json:"trace_file"`
+	// TraceEndpoint is the host:port of the OTLP http collector, defaults to the OTEL_EXPORTER_OTLP_* environment
+	TraceEndpoint string `json:"trace_endpoint"`
+	// StorageDir is the directory stores are persisted in, empty keeps stores in memory only
+	StorageDir string `json:"storage_dir"`
+
+	// LogLevel is the minimum level logged, "debug", "info", "warn" or "error"
+	LogLevel string `json:"log_level"`
+	// LogOutputs are where logs are written, "stdout" and "stderr" get console lines, other outputs are files appended with json lines
+	LogOutputs []string `json:"log_outputs"`
+
+	// CORSAllowedOrigins are the origins browsers may call the http api from, e.g. "https://example.com", "*" allows any
+	CORSAllowedOrigins []string `json:"cors_allowed_origins"`
+	// AdminToken is the bearer token required for store writes and reloads, empty disables them
+	AdminToken string `json:"admin_token"`
+
+	// MaxRequestBytes limits http request bodies, defaults to 1MiB
+	MaxRequestBytes int64 `json:"max_request_bytes"`
+	// MaxSearchDistance limits search distance in km, zero allows any distance
+	MaxSearchDistance int `json:"max_search_distance"`
+	// MaxNearestCount limits the number of nearest stores requested, defaults to 100
+	MaxNearestCount int `json:"max_nearest_count"`
+
+	// PrintConfig prints the configuration with secrets redacted instead of serving
+	PrintConfig bool `json:"-"`
+
+	// problems are the invalid environment and flag values found while loading
+	problems []error
+}
+
+// setting is a configuration value that can be overridden by environment and flags
+type setting struct {
+	key   string
+	usage string
+	// secret settings are only read from file and environment, keeping them out of process listings
+	secret bool
+	set    func(c *Configuration, v string) error
+}
+
+func (s setting) env() string {
+	return ENV_PREFIX + strings.ToUpper(s.key)
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(s.key, "_", "-")
+}
+
+var settings = []setting{
+	{key: "port", usage: "http port", set: intValue(func(c *Configuration) *int { return &c.Port })},
+	{key: "grpc_port", usage: "grpc port", set: intValue(func(c *Configuration) *int { return &c.GRPCPort })},
+	{key: "data_dir", usage: "directory relative data files are read from", set: stringValue(func(c *Configuration) *string { return &c.DataDir })},
+	{key: "data_files", usage: "comma separated list of store data files", set: listValue(func(c *Configuration) *[]string { return &c.DataFiles })},
+	{key: "data_format", usage: "format of store data files, inferred from file extension when empty", set: stringValue(func(c *Configuration) *string { return &c.DataFormat })},
+	{key: "reload_interval", usage: "how often data files are checked for changes, e.g. 30s", set: stringValue(func(c *Configuration) *string { return &c.ReloadInterval })},
+	{key: "storage_dir", usage: "directory stores are persisted in, stores are kept in memory only when empty", set: stringValue(func(c *Configuration) *string { return &c.StorageDir })},
+	{key: "geocoder_provider", usage: "geocoder provider, google or postal_file", set: stringValue(func(c *Configuration) *string { return &c.GeocoderProvider })},
+	{key: "geocoder_api_key", usage: "google geocoding api key", secret: true, set: stringValue(func(c *Configuration) *string { return &c.GEOCODER_API_KEY })},
+	{key: "geocoder_api_key_file", usage: "secret file holding the google geocoding api key, re-read when rotated", set: stringValue(func(c *Configuration) *string { return &c.GeocoderAPIKeyFile })},
+	{key: "geocoder_country", usage: "country postal codes are geocoded in", set: stringValue(func(c *Configuration) *string { return &c.GeocoderCountry })},
+	{key: "postal_code_file", usage: "geonames postal code file for the postal_file geocoder", set: stringValue(func(c *Configuration) *string { return &c.PostalCodeFile })},
+	{key: "geocoder_cache_size", usage: "number of geocoded postal codes cached, zero disables caching", set: intValue(func(c *Configuration) *int { return &c.GeocoderCacheSize })},
+	{key: "geocoder_cache_ttl", usage: "how long geocoded postal codes are cached, e.g. 720h", set: stringValue(func(c *Configuration) *string { return &c.GeocoderCacheTTL })},
+	{key: "geocoder_cache_file", usage: "file the geocoder cache is kept in across restarts", set: stringValue(func(c *Configuration) *string { return &c.GeocoderCacheFile })},
+	{key: "log_level", usage: "minimum level logged, debug, info, warn or error", set: stringValue(func(c *Configuration) *string { return &c.LogLevel })},
+	{key: "log_outputs", usage: "comma separated list of stdout, stderr or log file paths", set: listValue(func(c *Configuration) *[]string { return &c.LogOutputs })},
+	{key: "request_timeout", usage: "time spent serving an http request, e.g. 10s", set: stringValue(func(c *Configuration) *string { return &c.RequestTimeout })},
+	{key: "shutdown_timeout", usage: "time in-flight requests are given to finish on shutdown, e.g. 20s", set: stringValue(func(c *Configuration) *string { return &c.ShutdownTimeout })},
+	{key: "trace_exporter", usage: "trace span exporter, stdout, file or otlp", set: stringValue(func(c *Configuration) *string { return &c.TraceExporter })},
+	{key: "trace_file", usage: "file spans are appended to with the file exporter", set: stringValue(func(c *Configuration) *string { return &c.TraceFile })},
+	{key: "trace_endpoint", usage: "host:port of the otlp http collector", set: stringValue(func(c *Configuration) *string { return &c.TraceEndpoint })},
+	{key: "cors_allowed_origins", usage: "comma separated list of origins allowed to call the http api, * allows any", set: listValue(func(c *Configuration) *[]string { return &c.CORSAllowedOrigins })},
+	{key: "admin_token", usage: "bearer token required for store writes and reloads", secret: true, set: stringValue(func(c *Configuration) *string { return &c.AdminToken })},
+	{key: "max_request_bytes", usage: "maximum http request body size", set: int64Value(func(c *Configuration) *int64 { return &c.MaxRequestBytes })},
+	{key: "max_search_distance", usage: "maximum search distance in km, zero allows any", set: intValue(func(c *Configuration) *int { return &c.MaxSearchDistance })},
+	{key: "max_nearest_count", usage: "maximum number of nearest stores requested", set: intValue(func(c *Configuration) *int { return &c.MaxNearestCount })},
+}
+
+func stringValue(field func(c *Configuration) *string) func(*Configuration, string) error {
+	return func(c *Configuration, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func listValue(field func(c *Configuration) *[]string) func(*Configuration, string) error {
+	return func(c *Configuration, v string) error {
+		*field(c) = splitList(v)
+		return nil
+	}
+}
+
+func intValue(field func(c *Configuration) *int) func(*Configuration, string) error {
+	return func(c *Configuration, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func int64Value(field func(c *Configuration) *int64) func(*Configuration, string) error {
+	return func(c *Configuration, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// DataFilePaths returns the paths of configured store data files
+func (c *Configuration) DataFilePaths() []string {
+	dir := c.DataDir
+	if dir == "" {
+		dir = DEFAULT_DATA_DIR
+	}
+	files := c.DataFiles
+	if len(files) == 0 {
+		files = []string{DEFAULT_DATA_FILE}
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if filepath.IsAbs(f) {
+			paths = append(paths, f)
+		} else {
+			paths = append(paths, filepath.Join(dir, f))
+		}
+	}
+	return paths
+}
+
+// Load builds the configuration from the config file, overridden by
+// environment variables and then by command line args. The config file
+// is optional unless its path is given through args or environment.
+// Invalid environment and flag values are reported by Validate, along
+// with any other problems.
+func Load(args []string) (*Configuration, error) {
+	fs := flag.NewFlagSet("store-server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path of config json file, defaults to config.json in working directory")
+	printConfig := fs.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+	flagValues := map[string]*string{}
+	for _, st := range settings {
+		if st.secret {
+			continue
+		}
+		flagValues[st.flag()] = fs.String(st.flag(), "", fmt.Sprintf("%s (env %s)", st.usage, st.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	filePath := *configFile
+	if filePath == "" {
+		filePath = os.Getenv(CONFIG_FILE_ENV)
+	}
+
+	var config *Configuration
+	var err error
+	if filePath != "" {
+		config, err = GetConfigFromFile(filePath)
+	} else {
+		config, err = GetConfig()
+		if errors.Is(err, os.ErrNotExist) {
+			logging.Logger.Info("no config file, using defaults")
+			config, err = &Configuration{}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.PrintConfig = *printConfig
+
+	for _, st := range settings {
+		if v := os.Getenv(st.env()); v != "" {
+			if err := st.set(config, v); err != nil {
+				config.problems = append(config.problems, fmt.Errorf("%s: env %s %w", st.key, st.env(), err))
+			}
+		}
+	}
+
+	// flags given explicitly override, even when empty
+	bySetting := map[string]setting{}
+	for _, st := range settings {
+		bySetting[st.flag()] = st
+	}
+	fs.Visit(func(f *flag.Flag) {
+		st, ok := bySetting[f.Name]
+		if !ok || st.secret {
+			return
+		}
+		if err := st.set(config, *flagValues[f.Name]); err != nil {
+			config.problems = append(config.problems, fmt.Errorf("%s: flag -%s %w", st.key, f.Name, err))
+		}
+	})
+
+	config.setDefaults()
+	return config, nil
+}
+
+// setDefaults fills in unset values that have a default, so printed
+// configuration shows what the server runs with
+func (c *Configuration) setDefaults() {
+	if c.Port == 0 {
+		c.Port = constants.SERVICE_PORT
+	}
+	if c.GRPCPort == 0 {
+		c.GRPCPort = constants.GRPC_PORT
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = DEFAULT_LOG_LEVEL
+	}
+	if len(c.LogOutputs) == 0 {
+		c.LogOutputs = append([]string{}, DEFAULT_LOG_OUTPUTS...)
+	}
+	if c.RequestTimeout == "" {
+		c.RequestTimeout = constants.DEFAULT_REQUEST_TIMEOUT.String()
+	}
+	if c.ShutdownTimeout == "" {
+		c.ShutdownTimeout = constants.DEFAULT_SHUTDOWN_TIMEOUT.String()
+	}
+	if c.MaxRequestBytes == 0 {
+		c.MaxRequestBytes = DEFAULT_MAX_REQUEST_BYTES
+	}
+	if c.MaxNearestCount == 0 {
+		c.MaxNearestCount = DEFAULT_MAX_NEAREST_COUNT
+	}
 }
 
+// Validate checks the configuration, returning all problems found joined in one error
+func (c *Configuration) Validate() error {
+	problems := append([]error{}, c.problems...)
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		problem("port", "%d is not a valid port", c.Port)
+	}
+	if c.GRPCPort < 1 || c.GRPCPort > 65535 {
+		problem("grpc_port", "%d is not a valid port", c.GRPCPort)
+	}
+	if c.Port != 0 && c.Port == c.GRPCPort {
+		problem("grpc_port", "must differ from port %d", c.Port)
+	}
+
+	switch c.DataFormat {
+	case "", loader.JSON_FORMAT, loader.CSV_FORMAT, loader.NDJSON_FORMAT, loader.GEOJSON_FORMAT:
+	default:
+		problem("data_format", "unknown format %q", c.DataFormat)
+	}
+
+	if c.GEOCODER_API_KEY != "" && c.GeocoderAPIKeyFile != "" {
+		problem("geocoder_api_key_file", "set along with geocoder_api_key, only one may be set")
+	}
+	switch c.GeocoderProvider {
+	case "":
+	case "google":
+		if c.GEOCODER_API_KEY == "" && c.GeocoderAPIKeyFile == "" {
+			problem("geocoder_api_key", "geocoder_api_key or geocoder_api_key_file required for the google geocoder")
+		}
+	case "postal_file":
+		if c.PostalCodeFile == "" {
+			problem("postal_code_file", "required for the postal_file geocoder")
+		}
+	default:
+		problem("geocoder_provider", "unknown provider %q", c.GeocoderProvider)
+	}
+	if c.GeocoderCacheSize < 0 {
+		problem("geocoder_cache_size", "must not be negative")
+	}
+
+	durations := []struct {
+		key, value string
+		positive   bool
+	}{
+		{"reload_interval", c.ReloadInterval, true},
+		{"geocoder_cache_ttl", c.GeocoderCacheTTL, false},
+		{"request_timeout", c.RequestTimeout, false},
+		{"shutdown_timeout", c.ShutdownTimeout, false},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		switch {
+		case err != nil:
+			problem(d.key, "%q is not a duration", d.value)
+		case v < 0 || (d.positive && v == 0):
+			problem(d.key, "%q is out of range", d.value)
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		problem("log_level", "unknown level %q", c.LogLevel)
+	}
+	for _, o := range c.LogOutputs {
+		if strings.TrimSpace(o) == "" {
+			problem("log_outputs", "empty output")
+		}
+	}
+
+	switch c.TraceExporter {
+	case "", "stdout", "otlp":
+	case "file":
+		if c.TraceFile == "" {
+			problem("trace_file", "required for the file trace exporter")
+		}
+	default:
+		problem("trace_exporter", "unknown exporter %q", c.TraceExporter)
+	}
+
+	for _, o := range c.CORSAllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			problem("cors_allowed_origins", "%q is not an origin, e.g. https://example.com", o)
+		}
+	}
+
+	if c.MaxRequestBytes < 0 {
+		problem("max_request_bytes", "must not be negative")
+	}
+	if c.MaxSearchDistance < 0 {
+		problem("max_search_distance", "must not be negative")
+	}
+	if c.MaxNearestCount < 0 {
+		problem("max_nearest_count", "must not be negative")
+	}
+	return errors.Join(problems...)
+}
+
+// Redacted returns a copy of the configuration with secrets replaced
+func (c *Configuration) Redacted() *Configuration {
+	r := *c
+	if r.GEOCODER_API_KEY != "" {
+		r.GEOCODER_API_KEY = REDACTED
+	}
+	if r.AdminToken != "" {
+		r.AdminToken = REDACTED
+	}
+	return &r
+}
+
+// Print writes the configuration as indented json, with secrets redacted
+func (c *Configuration) Print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.Redacted())
+}
+
+// RequestTimeoutDuration returns the http request timeout, zero when disabled
+func (c *Configuration) RequestTimeoutDuration() time.Duration {
+	return parseDuration(c.RequestTimeout, constants.DEFAULT_REQUEST_TIMEOUT)
+}
+
+// ShutdownTimeoutDuration returns the time in-flight requests are given to finish on shutdown
+func (c *Configuration) ShutdownTimeoutDuration() time.Duration {
+	return parseDuration(c.ShutdownTimeout, constants.DEFAULT_SHUTDOWN_TIMEOUT)
+}
+
+// parseDuration parses a validated duration, returning def when it's unset or invalid
+func parseDuration(v string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// GetConfig reads configuration from config.json in the working directory
 func GetConfig() (*Configuration, error) {
 	rPath, err := os.Getwd()
 	if err != nil {
 		logging.Logger.Error("unable to access file path", zap.Error(err))
 		return nil, err
 	}
+	return GetConfigFromFile(filepath.Join(rPath, DEFAULT_CONFIG_FILE))
+}
 
-	var filePath string
-	if path.Base(rPath) == "config" {
-		bIdx := strings.Index(rPath, path.Base(rPath))
-		filePath = filepath.Join(string(rPath[:bIdx]), "config.json")
-	} else {
-		filePath = filepath.Join(rPath, "config.json")
-	}
-
-	_, err = os.Stat(filePath)
+// GetConfigFromFile reads configuration from given json file.
+// Relative file paths in it, including the default data directory,
+// are resolved against the file's directory.
+func GetConfigFromFile(filePath string) (*Configuration, error) {
+	_, err := os.Stat(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			logging.Logger.Error("file doesn't exist", zap.Error(err), zap.String("filePath", filePath))
@@ -42,7 +478,27 @@ func GetConfig() (*Configuration, error) {
 		return nil, err
 	}
 
-	return getFromConfigJson(filePath)
+	config, err := getFromConfigJson(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(filePath)
+	if config.DataDir == "" {
+		config.DataDir = DEFAULT_DATA_DIR
+	}
+	config.DataDir = resolvePath(dir, config.DataDir)
+	config.PostalCodeFile = resolvePath(dir, config.PostalCodeFile)
+	config.GeocoderAPIKeyFile = resolvePath(dir, config.GeocoderAPIKeyFile)
+	config.GeocoderCacheFile = resolvePath(dir, config.GeocoderCacheFile)
+	config.StorageDir = resolvePath(dir, config.StorageDir)
+	config.TraceFile = resolvePath(dir, config.TraceFile)
+	for i, o := range config.LogOutputs {
+		if o != "stdout" && o != "stderr" {
+			config.LogOutputs[i] = resolvePath(dir, o)
+		}
+	}
+	return config, nil
 }
 
 func getFromConfigJson(filePath string) (*Configuration, error) {
@@ -70,11 +526,24 @@ func getFromConfigJson(filePath string) (*Configuration, error) {
 			logging.Logger.Error("error decoding config json", zap.Error(err), zap.String("filePath", filePath))
 			return nil, err
 		}
-		if conf.GEOCODER_API_KEY == "" {
-			logging.Logger.Error("missing geocoder config", zap.Error(err), zap.String("filePath", filePath))
-			return nil, err
-		}
-		config.GEOCODER_API_KEY = conf.GEOCODER_API_KEY
+		*config = conf
 	}
 	return config, nil
 }
+
+func resolvePath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+func splitList(v string) []string {
+	items := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
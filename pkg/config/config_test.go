@@ -1,7 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -9,3 +14,78 @@ func TestGetConfig(t *testing.T) {
 	config, _ := GetConfig()
 	log.Printf("Config: %v\n", config)
 }
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "config.json")
+	data := `{"port": 8000, "grpc_port": 9000, "data_format": "csv", "storage_dir": "data", "log_level": "info", "geocoder_api_key": "file-key"}`
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STARBUCKS_PORT", "8001")
+	t.Setenv("STARBUCKS_GRPC_PORT", "9001")
+	t.Setenv("STARBUCKS_GEOCODER_API_KEY", "env-key")
+
+	config, err := Load([]string{"-config", filePath, "-port", "8002", "-log-level", "warn", "-data-format="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Port != 8002 || config.LogLevel != "warn" {
+		t.Errorf("expected flags to override, got port %d, log level %s", config.Port, config.LogLevel)
+	}
+	if config.GRPCPort != 9001 || config.GEOCODER_API_KEY != "env-key" {
+		t.Errorf("expected environment to override file, got grpc port %d", config.GRPCPort)
+	}
+	if config.DataFormat != "" {
+		t.Errorf("expected explicitly empty flag to override, got %s", config.DataFormat)
+	}
+	if config.StorageDir != filepath.Join(dir, "data") {
+		t.Errorf("expected storage dir relative to config file, got %s", config.StorageDir)
+	}
+	if config.ShutdownTimeout != "15s" || config.MaxRequestBytes != DEFAULT_MAX_REQUEST_BYTES {
+		t.Errorf("expected defaults for unset values, got %+v", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+
+	if _, err := Load([]string{"-config", filePath, "-geocoder-api-key", "flag-key"}); err == nil {
+		t.Errorf("expected secrets to not be accepted as flags")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv("STARBUCKS_MAX_NEAREST_COUNT", "many")
+	config, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.json")})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing config file error, got %v", err)
+	}
+
+	config, err = Load([]string{"-port", "70000", "-request-timeout", "soon", "-trace-exporter", "file", "-cors-allowed-origins", "*,example.com", "-geocoder-provider", "google"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = config.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, key := range []string{"max_nearest_count", "port", "request_timeout", "trace_file", "cors_allowed_origins", "geocoder_api_key"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("expected %s to be reported, got\n%v", key, err)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	config := &Configuration{GEOCODER_API_KEY: "AIza-secret", AdminToken: "s3cret", Port: 8080}
+	var buf bytes.Buffer
+	if err := config.Print(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "AIza-secret") || strings.Contains(buf.String(), "s3cret") || strings.Count(buf.String(), REDACTED) != 2 {
+		t.Errorf("expected secrets to be redacted, got\n%s", buf.String())
+	}
+	if config.GEOCODER_API_KEY != "AIza-secret" {
+		t.Errorf("expected configuration to be left unchanged")
+	}
+}
@@ -9,10 +9,41 @@ func (c ContextKey) String() string {
 }
 
 const SERVICE_PORT = 8080
+const GRPC_PORT = 9090
 const HEALTH_CHECK_URL = "/health"
+const LIVENESS_URL = "/livez"
+const READINESS_URL = "/readyz"
+const METRICS_URL = "/metrics"
 const SEARCH_URL = "/search"
+const NEAREST_URL = "/nearest"
+const EXPORT_URL = "/export"
+const ADMIN_RELOAD_URL = "/admin/reload"
+const STORE_URL = "/stores/{id:[0-9]+}"
+const STORES_URL = "/stores"
+const STORE_SEARCH_URL = "/stores/search"
+
+const MAX_BATCH_STORE_IDS = 100
+
+// number of stores returned by store attribute queries and text searches
+const DEFAULT_FIND_LIMIT = 100
+const DEFAULT_TEXT_SEARCH_LIMIT = 20
+const MAX_FIND_LIMIT = 1000
+
+const DEFAULT_NEAREST_COUNT = 1
+
+const DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+const DEFAULT_SHUTDOWN_TIMEOUT = 15 * time.Second
+
+// RETRY_AFTER_SECONDS is the Retry-After sent with searches made before store data is loaded
+const RETRY_AFTER_SECONDS = 5
 
 const READ_RATE = 500 * time.Millisecond
 const ReadRateContextKey = ContextKey("readrate")
 
-const FileNameContextKey = ContextKey("filename")
+const LoggerContextKey = ContextKey("logger")
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+const MAX_REQUEST_ID_LENGTH = 128
+
+const DataFilesContextKey = ContextKey("datafiles")
+const DataFormatContextKey = ContextKey("dataformat")
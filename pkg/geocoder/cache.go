@@ -0,0 +1,274 @@
+package geocoder
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hankgalt/starbucks/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const DEFAULT_CACHE_TTL = 24 * time.Hour
+
+// CACHE_SAVE_INTERVAL is how long cache updates are batched before they're written to the cache file
+const CACHE_SAVE_INTERVAL = 5 * time.Second
+
+// CacheStats reports geocode cache usage
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+// StatsReporter is implemented by geocoders that track cache usage
+type StatsReporter interface {
+	Stats() CacheStats
+}
+
+type cacheEntry struct {
+	Country    string    `json:"country"`
+	PostalCode string    `json:"postal_code"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Expires    time.Time `json:"expires"`
+}
+
+// CachingGeocoder is a least recently used, time bound cache of
+// postal code geo points in front of another geocoder, keyed by
+// country and postal code as the same code is used in several countries.
+// When a file path is set, entries for the country are reloaded from it on
+// creation, so restarts start warm, and updates are written to it in the
+// background at most every CACHE_SAVE_INTERVAL, with a final write on Close.
+type CachingGeocoder struct {
+	mu       sync.Mutex
+	geocoder Geocoder
+	country  string
+	size     int
+	ttl      time.Duration
+	filePath string
+	entries  map[string]*list.Element
+	lru      *list.List
+	hits     uint64
+	misses   uint64
+	now      func() time.Time
+	logger   *zap.Logger
+
+	// saveMu serializes writes to the cache file, so an older snapshot never replaces a newer one
+	saveMu    sync.Mutex
+	dirty     chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCachingGeocoder caches postal codes geo points of given geocoder, which geocodes in country
+func NewCachingGeocoder(geocoder Geocoder, country string, size int, ttl time.Duration, filePath string, logger *zap.Logger) *CachingGeocoder {
+	if ttl <= 0 {
+		ttl = DEFAULT_CACHE_TTL
+	}
+	cg := &CachingGeocoder{
+		geocoder: geocoder,
+		country:  strings.ToUpper(strings.TrimSpace(country)),
+		size:     size,
+		ttl:      ttl,
+		filePath: filePath,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+		logger:   logger,
+		dirty:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if filePath == "" {
+		close(cg.stopped)
+		return cg
+	}
+	if err := cg.load(); err != nil {
+		logger.Error("error loading geocode cache, starting cold", zap.Error(err), zap.String("filePath", filePath))
+	}
+	go cg.saveUpdates()
+	return cg
+}
+
+func (cg *CachingGeocoder) Geocode(ctx context.Context, postalCode string) (float64, float64, error) {
+	pc := strings.ToUpper(strings.TrimSpace(postalCode))
+	k := postalKey(cg.country, pc)
+
+	cg.mu.Lock()
+	if el, ok := cg.entries[k]; ok {
+		e := el.Value.(*cacheEntry)
+		if cg.now().Before(e.Expires) {
+			cg.lru.MoveToFront(el)
+			cg.hits++
+			cg.mu.Unlock()
+			cg.logger.Debug("geocode cache hit", zap.String("postalCode", postalCode))
+			return e.Latitude, e.Longitude, nil
+		}
+		cg.lru.Remove(el)
+		delete(cg.entries, k)
+	}
+	cg.misses++
+	cg.mu.Unlock()
+
+	lat, long, err := cg.geocoder.Geocode(ctx, postalCode)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cg.mu.Lock()
+	cg.put(&cacheEntry{Country: cg.country, PostalCode: pc, Latitude: lat, Longitude: long, Expires: cg.now().Add(cg.ttl)})
+	cg.mu.Unlock()
+
+	select {
+	case cg.dirty <- struct{}{}:
+	default:
+	}
+	return lat, long, nil
+}
+
+// saveUpdates writes the cache file once per CACHE_SAVE_INTERVAL with updates pending, until closed
+func (cg *CachingGeocoder) saveUpdates() {
+	defer close(cg.stopped)
+	for {
+		select {
+		case <-cg.done:
+			return
+		case <-cg.dirty:
+		}
+		select {
+		case <-cg.done:
+			return
+		case <-time.After(CACHE_SAVE_INTERVAL):
+		}
+		if err := cg.Save(); err != nil {
+			cg.logger.Error("error persisting geocode cache", zap.Error(err), zap.String("filePath", cg.filePath))
+		}
+	}
+}
+
+// Close stops background saves and writes the cache file a last time
+func (cg *CachingGeocoder) Close() error {
+	var err error
+	cg.closeOnce.Do(func() {
+		close(cg.done)
+		<-cg.stopped
+		err = cg.Save()
+	})
+	return err
+}
+
+// Check checks the cached geocoder when it depends on a remote service
+func (cg *CachingGeocoder) Check(ctx context.Context) error {
+	if c, ok := cg.geocoder.(Checker); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+func (cg *CachingGeocoder) Stats() CacheStats {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	return CacheStats{
+		Hits:   cg.hits,
+		Misses: cg.misses,
+		Size:   cg.lru.Len(),
+	}
+}
+
+// Save writes unexpired cache entries to the cache file, saves are serialized
+// so the last one to finish holds the latest entries
+func (cg *CachingGeocoder) Save() error {
+	if cg.filePath == "" {
+		return nil
+	}
+	cg.saveMu.Lock()
+	defer cg.saveMu.Unlock()
+
+	cg.mu.Lock()
+	now := cg.now()
+	entries := make([]*cacheEntry, 0, cg.lru.Len())
+	// least recently used first, so reloading restores the lru order
+	for el := cg.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*cacheEntry)
+		if now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	data, err := json.Marshal(entries)
+	cg.mu.Unlock()
+	if err != nil {
+		return errors.WrapError(err, "error marshalling geocode cache")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cg.filePath), filepath.Base(cg.filePath)+".*")
+	if err != nil {
+		return errors.WrapError(err, "error creating geocode cache file")
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.WrapError(err, "error writing geocode cache file")
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.WrapError(err, "error closing geocode cache file")
+	}
+	if err = os.Rename(tmp.Name(), cg.filePath); err != nil {
+		os.Remove(tmp.Name())
+		return errors.WrapError(err, "error replacing geocode cache file")
+	}
+	return nil
+}
+
+func (cg *CachingGeocoder) load() error {
+	data, err := os.ReadFile(cg.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WrapError(err, "error reading geocode cache file")
+	}
+
+	var entries []*cacheEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return errors.WrapError(err, "error decoding geocode cache file")
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	now := cg.now()
+	for _, e := range entries {
+		// entries cached for another country, or before entries had one, are dropped
+		if e.Country == cg.country && now.Before(e.Expires) {
+			cg.put(e)
+		}
+	}
+	cg.logger.Info("loaded geocode cache", zap.Int("count", cg.lru.Len()), zap.String("filePath", cg.filePath))
+	return nil
+}
+
+// put adds entry as most recently used, evicting the least recently used
+// entry when full. Callers must hold the lock.
+func (cg *CachingGeocoder) put(e *cacheEntry) {
+	k := postalKey(e.Country, e.PostalCode)
+	if el, ok := cg.entries[k]; ok {
+		el.Value = e
+		cg.lru.MoveToFront(el)
+		return
+	}
+	cg.entries[k] = cg.lru.PushFront(e)
+	for cg.size > 0 && cg.lru.Len() > cg.size {
+		el := cg.lru.Back()
+		cg.lru.Remove(el)
+		old := el.Value.(*cacheEntry)
+		delete(cg.entries, postalKey(old.Country, old.PostalCode))
+	}
+}
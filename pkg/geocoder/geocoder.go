@@ -0,0 +1,120 @@
+package geocoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+)
+
+const (
+	GOOGLE_PROVIDER      = "google"
+	POSTAL_FILE_PROVIDER = "postal_file"
+)
+
+const DEFAULT_COUNTRY = "US"
+
+var tracer = otel.Tracer("github.com/hankgalt/starbucks/pkg/geocoder")
+
+var (
+	ErrZeroResults    = errors.New("no results found")
+	ErrOverQueryLimit = errors.New("over quota request")
+	ErrRequestDenied  = errors.New("request was denied")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrServerError    = errors.New("server error, please, try again")
+	ErrUnknown        = errors.New("unknown error")
+
+	ErrInvalidPostalCode = errors.New("invalid postal code")
+)
+
+// MAX_POSTAL_CODE_LENGTH is the longest postal code accepted, postal codes in use are at most 10 characters
+const MAX_POSTAL_CODE_LENGTH = 10
+
+// Geocoder resolves a postal code to its latitude & longitude,
+// giving up when context is done
+type Geocoder interface {
+	Geocode(ctx context.Context, postalCode string) (lat, long float64, err error)
+}
+
+// Checker is implemented by geocoders that depend on a remote service,
+// Check returns an error when the service can't be reached
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// ValidatePostalCode checks postal code is made of ascii letters, digits, spaces and hyphens
+// and is at most MAX_POSTAL_CODE_LENGTH long, so it can't alter the geocoding request it's sent in
+func ValidatePostalCode(postalCode string) error {
+	pc := strings.TrimSpace(postalCode)
+	if pc == "" || len(pc) > MAX_POSTAL_CODE_LENGTH {
+		return fmt.Errorf("%w: %q", ErrInvalidPostalCode, postalCode)
+	}
+	for _, r := range pc {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || r == '-') {
+			return fmt.Errorf("%w: %q", ErrInvalidPostalCode, postalCode)
+		}
+	}
+	return nil
+}
+
+// New returns the geocoder for configured provider, defaults to google when an
+// api key is configured. Without a provider or api key no geocoder is needed and
+// a nil geocoder is returned. Results are cached when a cache size is configured.
+func New(cfg *config.Configuration, logger *zap.Logger) (Geocoder, error) {
+	gc, err := newProvider(cfg, logger)
+	if err != nil || gc == nil || cfg.GeocoderCacheSize <= 0 {
+		return gc, err
+	}
+
+	var ttl time.Duration
+	if cfg.GeocoderCacheTTL != "" {
+		ttl, err = time.ParseDuration(cfg.GeocoderCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geocoder cache ttl %q: %w", cfg.GeocoderCacheTTL, err)
+		}
+	}
+	return NewCachingGeocoder(gc, geocoderCountry(cfg), cfg.GeocoderCacheSize, ttl, cfg.GeocoderCacheFile, logger), nil
+}
+
+// geocoderCountry returns the country postal codes are geocoded in, DEFAULT_COUNTRY unless configured
+func geocoderCountry(cfg *config.Configuration) string {
+	if cfg.GeocoderCountry == "" {
+		return DEFAULT_COUNTRY
+	}
+	return cfg.GeocoderCountry
+}
+
+func newProvider(cfg *config.Configuration, logger *zap.Logger) (Geocoder, error) {
+	country := geocoderCountry(cfg)
+
+	switch cfg.GeocoderProvider {
+	case "":
+		if cfg.GEOCODER_API_KEY == "" && cfg.GeocoderAPIKeyFile == "" {
+			logger.Info("no geocoder configured, postal code search is disabled")
+			return nil, nil
+		}
+		fallthrough
+	case GOOGLE_PROVIDER:
+		if cfg.GeocoderAPIKeyFile != "" {
+			keyFile, err := NewKeyFile(cfg.GeocoderAPIKeyFile, logger)
+			if err != nil {
+				return nil, err
+			}
+			return NewGoogleGeocoderWithKeyFile(keyFile, country, logger), nil
+		}
+		if cfg.GEOCODER_API_KEY == "" {
+			return nil, errors.New("missing geocoder api key")
+		}
+		return NewGoogleGeocoder(cfg.GEOCODER_API_KEY, country, logger), nil
+	case POSTAL_FILE_PROVIDER:
+		return NewPostalFileGeocoder(cfg.PostalCodeFile, country, logger)
+	default:
+		return nil, fmt.Errorf("unknown geocoder provider: %s", cfg.GeocoderProvider)
+	}
+}
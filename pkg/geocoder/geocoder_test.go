@@ -0,0 +1,327 @@
+package geocoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func TestPostalFileGeocoder(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "US.txt")
+	data := "US\t92612\tIrvine\tCalifornia\tCA\tOrange\t059\t\t\t33.6607\t-117.8264\t4\n" +
+		"US\t10001\tNew York\tNew York\tNY\tNew York\t061\t\t\t40.7484\t-73.9967\t4\n" +
+		"CA\tH2X\tMontreal\tQuebec\tQC\t\t\t\t\t45.5088\t-73.5617\t\n" +
+		"malformed line\n"
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gc, err := New(&config.Configuration{GeocoderProvider: POSTAL_FILE_PROVIDER, PostalCodeFile: filePath}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lat, long, err := gc.Geocode(context.Background(), "92612")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lat != 33.6607 || long != -117.8264 {
+		t.Errorf("expected 33.6607, -117.8264, got %f, %f", lat, long)
+	}
+	if _, _, err = gc.Geocode(context.Background(), "H2X"); !errors.Is(err, ErrZeroResults) {
+		t.Errorf("expected zero results for postal code of another country, got %v", err)
+	}
+}
+
+func TestGoogleGeocoder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "test-key" {
+			fmt.Fprint(w, `{"status": "REQUEST_DENIED", "results": []}`)
+			return
+		}
+		if r.URL.Query().Get("components") != "country:US|postal_code:92612" {
+			fmt.Fprint(w, `{"status": "ZERO_RESULTS", "results": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "OK", "results": [{"geometry": {"location": {"lat": 33.66, "lng": -117.82}}}]}`)
+	}))
+	defer srv.Close()
+
+	gc := NewGoogleGeocoder("test-key", DEFAULT_COUNTRY, zap.NewNop())
+	gc.baseURL = srv.URL
+
+	lat, long, err := gc.Geocode(context.Background(), "92612")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lat != 33.66 || long != -117.82 {
+		t.Errorf("expected 33.66, -117.82, got %f, %f", lat, long)
+	}
+	if _, _, err = gc.Geocode(context.Background(), "00000"); !errors.Is(err, ErrZeroResults) {
+		t.Errorf("expected zero results error, got %v", err)
+	}
+
+	gc.apiKey = staticKey("bad-key")
+	if _, _, err = gc.Geocode(context.Background(), "92612"); !errors.Is(err, ErrRequestDenied) {
+		t.Errorf("expected request denied error, got %v", err)
+	}
+}
+
+func TestGoogleGeocoderInvalidPostalCode(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"status": "OK", "results": [{"geometry": {"location": {"lat": 48.85, "lng": 2.35}}}]}`)
+	}))
+	defer srv.Close()
+
+	gc := NewGoogleGeocoder("test-key", DEFAULT_COUNTRY, zap.NewNop())
+	gc.baseURL = srv.URL
+
+	for _, pc := range []string{"92612|country:FR", "92612&key=x", "", " ", "12345678901", "926\n12", "é1234"} {
+		if _, _, err := gc.Geocode(context.Background(), pc); !errors.Is(err, ErrInvalidPostalCode) {
+			t.Errorf("postal code %q: expected invalid postal code error, got %v", pc, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("expected invalid postal codes not to be sent, got %d requests", requests)
+	}
+
+	for _, pc := range []string{"92612", "92612-1234", "SW1A 1AA", " 98101 "} {
+		if err := ValidatePostalCode(pc); err != nil {
+			t.Errorf("postal code %q: unexpected error %v", pc, err)
+		}
+	}
+}
+
+func TestGoogleGeocoderCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	gc := NewGoogleGeocoder("test-key", DEFAULT_COUNTRY, zap.NewNop())
+	gc.baseURL = srv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := gc.Geocode(ctx, "92612"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestGoogleGeocoderCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "REQUEST_DENIED", "results": []}`)
+	}))
+
+	gc := NewGoogleGeocoder("test-key", DEFAULT_COUNTRY, zap.NewNop())
+	gc.baseURL = srv.URL
+	if err := gc.Check(context.Background()); err != nil {
+		t.Errorf("expected reachable geocoder, got %v", err)
+	}
+	srv.Close()
+	if err := gc.Check(context.Background()); err == nil {
+		t.Error("expected unreachable geocoder error")
+	}
+}
+
+type countingGeocoder struct {
+	calls int
+}
+
+func (g *countingGeocoder) Geocode(ctx context.Context, postalCode string) (float64, float64, error) {
+	g.calls++
+	if postalCode == "00000" {
+		return 0, 0, ErrZeroResults
+	}
+	return float64(g.calls), float64(g.calls), nil
+}
+
+func TestCachingGeocoder(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "geocode-cache.json")
+	now := time.Now()
+	src := &countingGeocoder{}
+	cg := NewCachingGeocoder(src, DEFAULT_COUNTRY, 2, time.Hour, filePath, zap.NewNop())
+	cg.now = func() time.Time { return now }
+
+	for _, pc := range []string{"92612", "92612", " 92612 ", "10001", "92612", "60601", "00000"} {
+		cg.Geocode(context.Background(), pc)
+	}
+	// 10001 is the least recently used and got evicted by 60601
+	if _, _, err := cg.Geocode(context.Background(), "10001"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if src.calls != 5 {
+		t.Errorf("expected 5 upstream calls, got %d", src.calls)
+	}
+	stats := cg.Stats()
+	if stats.Hits != 3 || stats.Misses != 5 || stats.Size != 2 {
+		t.Errorf("unexpected cache stats %+v", stats)
+	}
+
+	// entries expire after ttl
+	now = now.Add(2 * time.Hour)
+	cg.Geocode(context.Background(), "10001")
+	if src.calls != 6 {
+		t.Errorf("expected expired entry to be refreshed, got %d upstream calls", src.calls)
+	}
+
+	// a new cache starts warm from the file written on close
+	if err := cg.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	warm := NewCachingGeocoder(src, DEFAULT_COUNTRY, 2, time.Hour, filePath, zap.NewNop())
+	warm.now = func() time.Time { return now }
+	if _, _, err := warm.Geocode(context.Background(), "10001"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if src.calls != 6 || warm.Stats().Hits != 1 {
+		t.Errorf("expected warm cache hit, got %d upstream calls, stats %+v", src.calls, warm.Stats())
+	}
+	if err := warm.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the same postal code in another country isn't served from the cache
+	other := NewCachingGeocoder(src, "FR", 2, time.Hour, filePath, zap.NewNop())
+	defer other.Close()
+	other.now = func() time.Time { return now }
+	if size := other.Stats().Size; size != 0 {
+		t.Errorf("expected entries for another country to be dropped, got %d", size)
+	}
+	if _, _, err := other.Geocode(context.Background(), "10001"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if src.calls != 7 || other.Stats().Hits != 0 {
+		t.Errorf("expected cache miss for another country, got %d upstream calls, stats %+v", src.calls, other.Stats())
+	}
+}
+
+type pointGeocoder struct{}
+
+func (pointGeocoder) Geocode(ctx context.Context, postalCode string) (float64, float64, error) {
+	return 1, 1, nil
+}
+
+func TestCachingGeocoderConcurrentSaves(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "geocode-cache.json")
+	cg := NewCachingGeocoder(pointGeocoder{}, DEFAULT_COUNTRY, 100, time.Hour, filePath, zap.NewNop())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := cg.Geocode(context.Background(), fmt.Sprintf("%05d", i)); err != nil {
+				t.Error(err)
+			}
+			if err := cg.Save(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := cg.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	warm := NewCachingGeocoder(pointGeocoder{}, DEFAULT_COUNTRY, 100, time.Hour, filePath, zap.NewNop())
+	defer warm.Close()
+	if size := warm.Stats().Size; size != 20 {
+		t.Errorf("expected all 20 entries persisted, got %d", size)
+	}
+}
+
+func TestGoogleGeocoderTraceContext(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		fmt.Fprint(w, `{"status": "OK", "results": [{"geometry": {"location": {"lat": 33.66, "lng": -117.82}}}]}`)
+	}))
+	defer srv.Close()
+
+	gc := NewGoogleGeocoder("test-key", DEFAULT_COUNTRY, zap.NewNop())
+	gc.baseURL = srv.URL
+	if _, _, err := gc.Geocode(context.Background(), "92612"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 geocode span, got %d", len(spans))
+	}
+	sc := spans[0].SpanContext()
+	want := fmt.Sprintf("00-%s-%s-01", sc.TraceID(), sc.SpanID())
+	if traceparent != want {
+		t.Errorf("expected traceparent %s, got %q", want, traceparent)
+	}
+}
+
+func TestGoogleGeocoderKeyFile(t *testing.T) {
+	var lastKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastKey = r.URL.Query().Get("key")
+		fmt.Fprint(w, `{"status": "OK", "results": [{"geometry": {"location": {"lat": 33.66, "lng": -117.82}}}]}`)
+	}))
+	defer srv.Close()
+
+	filePath := filepath.Join(t.TempDir(), "geocoder-api-key")
+	if err := os.WriteFile(filePath, []byte("first-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gc, err := New(&config.Configuration{GeocoderAPIKeyFile: filePath}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	google := gc.(*GoogleGeocoder)
+	google.baseURL = srv.URL
+	if _, _, err := gc.Geocode(context.Background(), "92612"); err != nil || lastKey != "first-key" {
+		t.Fatalf("expected first key to be sent, got %q, %v", lastKey, err)
+	}
+
+	// rotated key is picked up without restarting, a missing file keeps the last key
+	if err := os.WriteFile(filePath, []byte("rotated-key-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gc.Geocode(context.Background(), "92612"); err != nil || lastKey != "rotated-key-2" {
+		t.Errorf("expected rotated key to be sent, got %q, %v", lastKey, err)
+	}
+	os.Remove(filePath)
+	if _, _, err := gc.Geocode(context.Background(), "92612"); err != nil || lastKey != "rotated-key-2" {
+		t.Errorf("expected last key to be kept, got %q, %v", lastKey, err)
+	}
+
+	// request errors don't leak the key
+	srv.Close()
+	if _, _, err := gc.Geocode(context.Background(), "92612"); err == nil || strings.Contains(err.Error(), "rotated-key-2") {
+		t.Errorf("expected request error with key redacted, got %v", err)
+	}
+
+	if _, err := New(&config.Configuration{GeocoderAPIKeyFile: filePath}, zap.NewNop()); err == nil {
+		t.Error("expected error reading missing key file")
+	}
+	if gc, err := New(&config.Configuration{}, zap.NewNop()); gc != nil || err != nil {
+		t.Errorf("expected no geocoder without provider or key, got %v, %v", gc, err)
+	}
+}
@@ -0,0 +1,199 @@
+package geocoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const GOOGLE_GEOCODER_URL = "https://maps.google.com/maps/api/geocode/json"
+
+// GoogleGeocoder geocodes postal codes using google maps geocoding api
+type GoogleGeocoder struct {
+	// apiKey returns the key sent with each request, looked up per request so rotated keys are used
+	apiKey  func() (string, error)
+	country string
+	baseURL string
+	client  *http.Client
+	logger  *zap.Logger
+}
+
+func NewGoogleGeocoder(apiKey, country string, logger *zap.Logger) *GoogleGeocoder {
+	return newGoogleGeocoder(staticKey(apiKey), country, logger)
+}
+
+// NewGoogleGeocoderWithKeyFile creates a google geocoder sending the key held
+// in given file, the file is re-read when the key is rotated
+func NewGoogleGeocoderWithKeyFile(keyFile *KeyFile, country string, logger *zap.Logger) *GoogleGeocoder {
+	return newGoogleGeocoder(keyFile.Key, country, logger)
+}
+
+func newGoogleGeocoder(apiKey func() (string, error), country string, logger *zap.Logger) *GoogleGeocoder {
+	return &GoogleGeocoder{
+		apiKey:  apiKey,
+		country: country,
+		baseURL: GOOGLE_GEOCODER_URL,
+		client:  http.DefaultClient,
+		logger:  logger,
+	}
+}
+
+func staticKey(key string) func() (string, error) {
+	return func() (string, error) {
+		return key, nil
+	}
+}
+
+func (g *GoogleGeocoder) Geocode(ctx context.Context, postalCode string) (float64, float64, error) {
+	if err := ValidatePostalCode(postalCode); err != nil {
+		return 0, 0, err
+	}
+	key, err := g.apiKey()
+	if err != nil {
+		g.logger.Error("error getting geocoder api key", zap.Error(err))
+		metrics.GeocoderRequests.WithLabelValues(GOOGLE_PROVIDER, metrics.GEOCODER_REQUEST_ERROR).Inc()
+		return 0, 0, err
+	}
+	q := url.Values{}
+	q.Set("components", "country:"+g.country+"|postal_code:"+strings.TrimSpace(postalCode))
+	q.Set("sensor", "false")
+	q.Set("key", key)
+
+	ctx, span := tracer.Start(ctx, "GET geocode", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", http.MethodGet),
+		attribute.String("server.address", g.baseURL),
+	))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	r, err := g.client.Do(req)
+	if err != nil {
+		err = redactKey(err, key)
+		span.SetStatus(codes.Error, err.Error())
+		g.logger.Error("geocoder request error", zap.Error(err), zap.String("postalCode", postalCode))
+		metrics.GeocoderRequests.WithLabelValues(GOOGLE_PROVIDER, metrics.GEOCODER_REQUEST_ERROR).Inc()
+		return 0, 0, err
+	}
+	defer r.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", r.StatusCode))
+
+	var results GeocoderResults
+	err = json.NewDecoder(r.Body).Decode(&results)
+	if err != nil {
+		g.logger.Error("error decoding geocode response", zap.Error(err), zap.String("postalCode", postalCode))
+		metrics.GeocoderRequests.WithLabelValues(GOOGLE_PROVIDER, metrics.GEOCODER_DECODE_ERROR).Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return 0, 0, err
+	}
+	metrics.GeocoderRequests.WithLabelValues(GOOGLE_PROVIDER, strings.ToUpper(results.Status)).Inc()
+	span.SetAttributes(attribute.String("geocoder.status", results.Status))
+
+	if strings.ToUpper(results.Status) != "OK" {
+		// If the status is not "OK" check what status was returned
+		switch strings.ToUpper(results.Status) {
+		case "ZERO_RESULTS":
+			err = ErrZeroResults
+		case "OVER_QUERY_LIMIT":
+			err = ErrOverQueryLimit
+		case "REQUEST_DENIED":
+			err = ErrRequestDenied
+		case "INVALID_REQUEST":
+			err = ErrInvalidRequest
+		case "UNKNOWN_ERROR":
+			err = ErrServerError
+		default:
+			err = ErrUnknown
+		}
+		g.logger.Error("geocode response error", zap.Error(err), zap.String("postalCode", postalCode))
+		return 0, 0, err
+	}
+	if len(results.Results) == 0 {
+		return 0, 0, ErrZeroResults
+	}
+	lat, long := results.Results[0].Geometry.Location.Lat, results.Results[0].Geometry.Location.Lng
+	g.logger.Debug("geocoder geopoint response", zap.Float64("latitude", lat), zap.Float64("longitude", long))
+	return lat, long, nil
+}
+
+// redactKey masks the api key in errors carrying the request url, keeping it out of logs and spans
+func redactKey(err error, key string) error {
+	var ue *url.Error
+	if key == "" || !errors.As(err, &ue) {
+		return err
+	}
+	return &url.Error{Op: ue.Op, URL: strings.ReplaceAll(ue.URL, url.QueryEscape(key), config.REDACTED), Err: ue.Err}
+}
+
+// Check verifies the geocoding api can be reached, without spending quota on a geocode request
+func (g *GoogleGeocoder) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, g.baseURL, nil)
+	if err != nil {
+		return err
+	}
+	r, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("geocoding api returned %s", r.Status)
+	}
+	return nil
+}
+
+type GeocoderResults struct {
+	Results []Result `json:"results"`
+	Status  string   `json:"status"`
+}
+
+type Result struct {
+	AddressComponents []Address `json:"address_components"`
+	FormattedAddress  string    `json:"formatted_address"`
+	Geometry          Geometry  `json:"geometry"`
+	PlaceId           string    `json:"place_id"`
+	Types             []string  `json:"types"`
+}
+
+// Address store each address is identified by the 'types'
+type Address struct {
+	LongName  string   `json:"long_name"`
+	ShortName string   `json:"short_name"`
+	Types     []string `json:"types"`
+}
+
+// Geometry store each value in the geometry
+type Geometry struct {
+	Bounds       Bounds `json:"bounds"`
+	Location     LatLng `json:"location"`
+	LocationType string `json:"location_type"`
+	Viewport     Bounds `json:"viewport"`
+}
+
+// Bounds Northeast and Southwest
+type Bounds struct {
+	Northeast LatLng `json:"northeast"`
+	Southwest LatLng `json:"southwest"`
+}
+
+// LatLng store the latitude and longitude
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
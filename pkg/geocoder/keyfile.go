@@ -0,0 +1,73 @@
+package geocoder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// KeyFile reads an api key from a secret file, e.g. one mounted under /run/secrets.
+// The file is re-read when it changes, so a rotated key is used without a restart.
+type KeyFile struct {
+	path    string
+	mu      sync.Mutex
+	key     string
+	modTime time.Time
+	size    int64
+	logger  *zap.Logger
+}
+
+// NewKeyFile reads the api key from given file, failing when it can't be read or is empty
+func NewKeyFile(path string, logger *zap.Logger) (*KeyFile, error) {
+	k := &KeyFile{path: path, logger: logger}
+	if _, err := k.Key(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Key returns the current api key, re-reading the file when its modification time
+// or size changed. The last key read is kept if the file can't be read mid rotation.
+func (k *KeyFile) Key() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	fi, err := os.Stat(k.path)
+	if err == nil && k.key != "" && fi.ModTime().Equal(k.modTime) && fi.Size() == k.size {
+		return k.key, nil
+	}
+	var key string
+	if err == nil {
+		key, err = k.read()
+	}
+	if err != nil {
+		if k.key != "" {
+			k.logger.Warn("error re-reading api key file, using last key", zap.Error(err), zap.String("filePath", k.path))
+			return k.key, nil
+		}
+		return "", err
+	}
+
+	if k.key != "" && key != k.key {
+		k.logger.Info("api key file changed, using rotated key", zap.String("filePath", k.path))
+	}
+	k.key, k.modTime, k.size = key, fi.ModTime(), fi.Size()
+	return k.key, nil
+}
+
+func (k *KeyFile) read() (string, error) {
+	b, err := os.ReadFile(k.path)
+	if err != nil {
+		return "", fmt.Errorf("error reading api key file: %w", err)
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", errors.New("api key file is empty")
+	}
+	return key, nil
+}
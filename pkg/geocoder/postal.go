@@ -0,0 +1,103 @@
+package geocoder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hankgalt/starbucks/pkg/errors"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// GeoNames postal code dump column positions
+const (
+	postalCountryCol = 0
+	postalCodeCol    = 1
+	postalLatCol     = 9
+	postalLongCol    = 10
+)
+
+type postalPoint struct {
+	lat  float64
+	long float64
+}
+
+// PostalFileGeocoder geocodes postal codes offline, from a GeoNames style
+// tab separated file of postal code centroids:
+// country code, postal code, place name, admin name1, admin code1, admin name2,
+// admin code2, admin name3, admin code3, latitude, longitude, accuracy
+type PostalFileGeocoder struct {
+	country string
+	points  map[string]postalPoint
+	logger  *zap.Logger
+}
+
+func NewPostalFileGeocoder(filePath, country string, logger *zap.Logger) (*PostalFileGeocoder, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("missing postal code file")
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		logger.Error("error opening postal code file", zap.Error(err), zap.String("filePath", filePath))
+		return nil, errors.WrapError(err, "error opening postal code file: %s", filePath)
+	}
+	defer f.Close()
+
+	g := &PostalFileGeocoder{
+		country: strings.ToUpper(country),
+		points:  map[string]postalPoint{},
+		logger:  logger,
+	}
+
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		cols := strings.Split(sc.Text(), "\t")
+		if len(cols) <= postalLongCol {
+			logger.Error("skipping malformed postal code record", zap.Int("line", line), zap.String("filePath", filePath))
+			continue
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(cols[postalLatCol]), 64)
+		if err != nil {
+			logger.Error("skipping postal code record with invalid latitude", zap.Error(err), zap.Int("line", line))
+			continue
+		}
+		long, err := strconv.ParseFloat(strings.TrimSpace(cols[postalLongCol]), 64)
+		if err != nil {
+			logger.Error("skipping postal code record with invalid longitude", zap.Error(err), zap.Int("line", line))
+			continue
+		}
+		k := postalKey(cols[postalCountryCol], cols[postalCodeCol])
+		if _, ok := g.points[k]; !ok {
+			g.points[k] = postalPoint{lat: lat, long: long}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		logger.Error("error reading postal code file", zap.Error(err), zap.String("filePath", filePath))
+		return nil, errors.WrapError(err, "error reading postal code file: %s", filePath)
+	}
+	logger.Info("loaded postal codes", zap.Int("count", len(g.points)), zap.String("filePath", filePath))
+	return g, nil
+}
+
+func (g *PostalFileGeocoder) Geocode(ctx context.Context, postalCode string) (float64, float64, error) {
+	p, ok := g.points[postalKey(g.country, postalCode)]
+	if !ok {
+		g.logger.Error("geocode response error", zap.Error(ErrZeroResults), zap.String("postalCode", postalCode))
+		metrics.GeocoderRequests.WithLabelValues(POSTAL_FILE_PROVIDER, "ZERO_RESULTS").Inc()
+		return 0, 0, ErrZeroResults
+	}
+	metrics.GeocoderRequests.WithLabelValues(POSTAL_FILE_PROVIDER, "OK").Inc()
+	g.logger.Debug("geocoder geopoint response", zap.Float64("latitude", p.lat), zap.Float64("longitude", p.long))
+	return p.lat, p.long, nil
+}
+
+func postalKey(country, postalCode string) string {
+	return strings.ToUpper(strings.TrimSpace(country)) + ":" + strings.ToUpper(strings.TrimSpace(postalCode))
+}
@@ -0,0 +1,270 @@
+package listing
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/xerra/common/vincenty"
+)
+
+// dataset is a snapshot of store data, its spatial index, its secondary indexes
+// on country and city and its text index on name and city. Reloads build a fresh
+// dataset and swap it into the gateway, queries in flight keep working on the
+// dataset they started with.
+type dataset struct {
+	mu           sync.RWMutex
+	stores       map[uint32]*Store
+	index        *cellIndex
+	byCountry    *attrIndex
+	byCity       *attrIndex
+	text         *textIndex
+	version      uint64
+	loadedAt     time.Time
+	loadDuration time.Duration
+}
+
+func newDataset() *dataset {
+	return &dataset{
+		stores:    map[uint32]*Store{},
+		index:     newCellIndex(DEFAULT_CELL_SIZE),
+		byCountry: newAttrIndex(),
+		byCity:    newAttrIndex(),
+		text:      newTextIndex(),
+	}
+}
+
+// add indexes a new store, returns false if store id already exists
+func (ds *dataset) add(s *Store) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.lookup(s.Id) != nil {
+		return false
+	}
+	ds.index.add(s.Id, s.Latitude, s.Longitude)
+	ds.byCountry.add(s.Id, s.Country)
+	ds.byCity.add(s.Id, s.City)
+	ds.text.add(s.Id, s.Name, s.City)
+	ds.stores[s.Id] = s
+	return true
+}
+
+// put adds or replaces a store, re-indexing it.
+// Stores are never modified in place, readers may hold the old one.
+func (ds *dataset) put(s *Store) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if old := ds.lookup(s.Id); old != nil {
+		ds.byCountry.remove(old.Id, old.Country)
+		ds.byCity.remove(old.Id, old.City)
+		ds.text.remove(old.Id, old.Name, old.City)
+	}
+	ds.index.add(s.Id, s.Latitude, s.Longitude)
+	ds.byCountry.add(s.Id, s.Country)
+	ds.byCity.add(s.Id, s.City)
+	ds.text.add(s.Id, s.Name, s.City)
+	ds.stores[s.Id] = s
+}
+
+// remove drops store from the dataset and its indexes, returns false if it doesn't exist
+func (ds *dataset) remove(id uint32) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	s := ds.lookup(id)
+	if s == nil {
+		return false
+	}
+	ds.index.remove(id)
+	ds.byCountry.remove(id, s.Country)
+	ds.byCity.remove(id, s.City)
+	ds.text.remove(id, s.Name, s.City)
+	delete(ds.stores, id)
+	return true
+}
+
+// lookup returns store for given id, nil if it doesn't exist.
+// Callers must hold the read lock.
+func (ds *dataset) lookup(k uint32) *Store {
+	v, ok := ds.stores[k]
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// CTX_CHECK_INTERVAL is the number of distance calculations between context checks
+const CTX_CHECK_INTERVAL = 256
+
+// candidates returns the ids of stores that may match a normalized filter, looked up
+// in the more selective of its country and city indexes. Returns false if filter
+// has no indexed attribute. Callers must hold the read lock.
+func (ds *dataset) candidates(f *StoreFilter) ([]uint32, bool) {
+	switch {
+	case !f.indexed():
+		return nil, false
+	case f.City == "":
+		return ds.byCountry.lookup(f.Countries...), true
+	case len(f.Countries) == 0 || ds.byCity.count(f.City) <= ds.byCountry.count(f.Countries...):
+		return ds.byCity.lookup(f.City), true
+	default:
+		return ds.byCountry.lookup(f.Countries...), true
+	}
+}
+
+// candidateCount returns the number of ids candidates returns for a normalized filter.
+// Callers must hold the read lock.
+func (ds *dataset) candidateCount(f *StoreFilter) int {
+	switch {
+	case !f.indexed():
+		return 0
+	case f.City == "":
+		return ds.byCountry.count(f.Countries...)
+	case len(f.Countries) == 0:
+		return ds.byCity.count(f.City)
+	default:
+		return min(ds.byCity.count(f.City), ds.byCountry.count(f.Countries...))
+	}
+}
+
+// storesWithin returns candidate stores within dist km of given point that match
+// a normalized filter, annotated with their distance and bearing and sorted nearest first.
+// Returns the context error if context is done before all candidates are checked.
+// Callers must hold the read lock.
+func (ds *dataset) storesWithin(ctx context.Context, lat, long, dist float64, ids []uint32, f *StoreFilter) ([]*StoreResult, error) {
+	origin := vincenty.LatLng{Latitude: lat, Longitude: long}
+	results := []*StoreResult{}
+	for i, v := range ids {
+		if i%CTX_CHECK_INTERVAL == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		store := ds.lookup(v)
+		if store == nil || !f.matches(store) {
+			continue
+		}
+		pos := vincenty.LatLng{Latitude: store.Latitude, Longitude: store.Longitude}
+		d := distanceBetween(origin, pos)
+		if d.Kilometers() <= dist {
+			bearing := initialBearing(origin, pos)
+			results = append(results, &StoreResult{
+				Store:         store,
+				DistanceKm:    d.Kilometers(),
+				DistanceMiles: d.Miles(),
+				Bearing:       &bearing,
+			})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].DistanceKm == results[j].DistanceKm {
+			return results[i].Id < results[j].Id
+		}
+		return results[i].DistanceKm < results[j].DistanceKm
+	})
+	return results, nil
+}
+
+// GEO_BOOST_WEIGHT scales the boost a text search gives stores near its origin,
+// a store at the origin scores up to 1 + GEO_BOOST_WEIGHT times its text score
+const GEO_BOOST_WEIGHT = 1.0
+
+// GEO_BOOST_SCALE_KM is the distance at which the geo boost of a text search halves
+const GEO_BOOST_SCALE_KM = 50.0
+
+// searchText scores the stores matching any of given query tokens, a store's score is the
+// average over tokens of its best match weighted by field. Stores near origin, when given,
+// are boosted. Returns results sorted by score, best first.
+// Callers must hold the read lock.
+func (ds *dataset) searchText(ctx context.Context, tokens []string, origin *GeoPoint) ([]*TextResult, error) {
+	scores := map[uint32]float64{}
+	for _, token := range tokens {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		matches, err := ds.text.match(token, ctx.Err)
+		if err != nil {
+			return nil, err
+		}
+		best := map[uint32]float64{}
+		for _, m := range matches {
+			for id, fields := range ds.text.postings[m.term] {
+				w := CITY_FIELD_WEIGHT
+				if fields&NAME_FIELD != 0 {
+					w = NAME_FIELD_WEIGHT
+				}
+				best[id] = max(best[id], m.weight*w)
+			}
+		}
+		for id, w := range best {
+			scores[id] += w / float64(len(tokens))
+		}
+	}
+
+	var from vincenty.LatLng
+	if origin != nil {
+		from = vincenty.LatLng{Latitude: origin.Latitude, Longitude: origin.Longitude}
+	}
+	results := make([]*TextResult, 0, len(scores))
+	i := 0
+	for id, score := range scores {
+		if i++; i%CTX_CHECK_INTERVAL == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		store := ds.lookup(id)
+		if store == nil {
+			continue
+		}
+		res := &TextResult{Store: store, Score: score}
+		if origin != nil {
+			d := distanceBetween(from, vincenty.LatLng{Latitude: store.Latitude, Longitude: store.Longitude}).Kilometers()
+			res.DistanceKm = &d
+			res.Score *= 1 + GEO_BOOST_WEIGHT/(1+d/GEO_BOOST_SCALE_KM)
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score == results[j].Score {
+			return results[i].Id < results[j].Id
+		}
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// fileStamp identifies a version of a data file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFiles returns the current stamp of each given file, missing files are left out
+func statFiles(filePaths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(filePaths))
+	for _, fp := range filePaths {
+		fi, err := os.Stat(fp)
+		if err != nil {
+			continue
+		}
+		stamps[fp] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.modTime.Equal(v.modTime) || w.size != v.size {
+			return false
+		}
+	}
+	return true
+}
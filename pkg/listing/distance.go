@@ -0,0 +1,41 @@
+package listing
+
+import (
+	"math"
+
+	"gitlab.com/xerra/common/vincenty"
+)
+
+// MEAN_EARTH_RADIUS_M is the mean earth radius in meters, used when vincenty fails to converge
+const MEAN_EARTH_RADIUS_M = 6371008.8
+
+// MAX_SEARCH_DISTANCE_KM is half the earth's equatorial circumference, no two points are further apart
+const MAX_SEARCH_DISTANCE_KM = 20038.0
+
+// NEAREST_START_DISTANCE_KM is the initial search radius of a nearest stores query
+const NEAREST_START_DISTANCE_KM = 5.0
+
+// distanceBetween returns the geodesic distance between two points.
+// vincenty.Inverse doesn't converge for nearly antipodal points,
+// in which case the great circle distance is returned instead.
+func distanceBetween(origin, pos vincenty.LatLng) vincenty.Distance {
+	d := vincenty.Inverse(origin, pos)
+	if d >= 0 {
+		return d
+	}
+	lat1, lat2 := origin.Latitude*math.Pi/180, pos.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLong := (pos.Longitude - origin.Longitude) * math.Pi / 180
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLong/2), 2)
+	return vincenty.Distance(2 * MEAN_EARTH_RADIUS_M * math.Asin(math.Min(1, math.Sqrt(h))))
+}
+
+// initialBearing returns the initial great circle bearing from origin to pos, in degrees clockwise from north
+func initialBearing(origin, pos vincenty.LatLng) float64 {
+	lat1, lat2 := origin.Latitude*math.Pi/180, pos.Latitude*math.Pi/180
+	dLong := (pos.Longitude - origin.Longitude) * math.Pi / 180
+	y := math.Sin(dLong) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLong)
+	b := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(b+360, 360)
+}
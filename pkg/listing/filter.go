@@ -0,0 +1,66 @@
+package listing
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ErrFilterRequired is returned by attribute queries without an indexed attribute to look stores up by
+var ErrFilterRequired = errors.New("country or city filter required")
+
+// StoreFilter narrows stores down by their attributes, empty fields match any store.
+// Matching is case insensitive.
+type StoreFilter struct {
+	// Countries are the country codes to match any of, e.g. ["US", "CA"]
+	Countries []string `json:"countries"`
+	// City is the city to match
+	City string `json:"city"`
+	// Name is a substring of the store name to match
+	Name string `json:"name"`
+}
+
+// normalize returns a copy of filter with values trimmed and lower cased
+// for matching, nil if filter matches any store
+func (f *StoreFilter) normalize() *StoreFilter {
+	if f == nil {
+		return nil
+	}
+	nf := &StoreFilter{
+		City: normalizeAttr(f.City),
+		Name: normalizeAttr(f.Name),
+	}
+	for _, c := range f.Countries {
+		if c = normalizeAttr(c); c != "" && !slices.Contains(nf.Countries, c) {
+			nf.Countries = append(nf.Countries, c)
+		}
+	}
+	if len(nf.Countries) == 0 && nf.City == "" && nf.Name == "" {
+		return nil
+	}
+	return nf
+}
+
+// indexed reports whether a normalized filter has an attribute stores can be looked up by
+func (f *StoreFilter) indexed() bool {
+	return f != nil && (len(f.Countries) > 0 || f.City != "")
+}
+
+// matches reports whether store matches a normalized filter, a nil filter matches any store
+func (f *StoreFilter) matches(s *Store) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Countries) > 0 && !slices.Contains(f.Countries, normalizeAttr(s.Country)) {
+		return false
+	}
+	if f.City != "" && normalizeAttr(s.City) != f.City {
+		return false
+	}
+	return f.Name == "" || strings.Contains(strings.ToLower(s.Name), f.Name)
+}
+
+// normalizeAttr returns the form store attributes are indexed and matched by
+func normalizeAttr(v string) string {
+	return strings.ToLower(strings.TrimSpace(v))
+}
@@ -5,68 +5,264 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
-	"net/http"
-	"strings"
+	"io"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/hankgalt/starbucks/pkg/config"
 	"github.com/hankgalt/starbucks/pkg/constants"
+	apperrors "github.com/hankgalt/starbucks/pkg/errors"
+	"github.com/hankgalt/starbucks/pkg/geocoder"
 	"github.com/hankgalt/starbucks/pkg/loader"
+	"github.com/hankgalt/starbucks/pkg/logging"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-
-	// "github.com/paultag/go-haversine"
-	"gitlab.com/xerra/common/vincenty"
 )
 
+// Gateway is the store listing backend served over http and grpc.
+// Distances are in km, search results are sorted nearest first.
+// Queries stop with the context error once their context is done.
 type Gateway interface {
-	ProcessFile()
-	GetStore(storeId uint32) (*Store, error)
-	GetStoresForGeoPoint(lat, long, dist float64) ([]*Store, error)
+	GetStore(ctx context.Context, storeId uint32) (*Store, error)
+	GetStores(ctx context.Context, storeIds []uint32) ([]*Store, []uint32)
+	GetStoresForGeoPoint(ctx context.Context, lat, long float64, dist int, filter *StoreFilter) ([]*StoreResult, error)
+	GetStoresForPostalCode(ctx context.Context, postalCode string, dist int, filter *StoreFilter) (*GeoPoint, []*StoreResult, error)
+	GetNearestStores(ctx context.Context, lat, long float64, k int) ([]*StoreResult, error)
+	FindStores(ctx context.Context, filter *StoreFilter, limit int) ([]*Store, int, error)
+	SearchStores(ctx context.Context, query string, origin *GeoPoint, limit int) ([]*TextResult, error)
+
+	AddStore(ctx context.Context, s *Store) (*Store, error)
+	UpdateStore(ctx context.Context, s *Store) (*Store, error)
+	PatchStore(ctx context.Context, storeId uint32, patch *StorePatch) (*Store, error)
+	DeleteStore(ctx context.Context, storeId uint32) error
+
+	ExportNDJSON(ctx context.Context, w io.Writer) (int, error)
+	Reload() error
 	GetStoreStats() GatewayStats
+	CheckReadiness(ctx context.Context) Readiness
 }
 
+var _ Gateway = (*JsonGateway)(nil)
+
+var tracer = otel.Tracer("github.com/hankgalt/starbucks/pkg/listing")
+
+// Storage durably persists stores as json values keyed by store id.
+// Rebase replaces stored values except for the ones written through Put and Delete,
+// ForEachWrite lists those writes with a nil value for deleted ids.
+type Storage interface {
+	Len() int
+	ForEach(fn func(id uint32, value []byte) error) error
+	ForEachWrite(fn func(id uint32, value []byte) error) error
+	Put(id uint32, value []byte) error
+	Delete(id uint32) error
+	Rebase(values map[uint32][]byte) error
+}
+
+// ErrLoadInProgress is returned when a store data reload is requested while one is running
+var ErrLoadInProgress = errors.New("store data load already in progress")
+
+// ErrNoStores is returned by a load that found no valid stores in the data files
+var ErrNoStores = errors.New("no valid stores in store data files")
+
+// ErrPostalSearchUnavailable is returned by postal code searches when no geocoder is configured
+var ErrPostalSearchUnavailable = errors.New("postal code search is not available")
+
+// GEOCODER_CHECK_TIMEOUT bounds the geocoder reachability check of a readiness check
+const GEOCODER_CHECK_TIMEOUT = 2 * time.Second
+
+// GEOCODER_CHECK_TTL is how long readiness checks reuse the outcome of a geocoder reachability check
+const GEOCODER_CHECK_TTL = 30 * time.Second
+
+// readiness check outcomes
+const (
+	CHECK_OK             = "ok"
+	CHECK_NOT_LOADED     = "not loaded"
+	CHECK_NOT_CONFIGURED = "not configured"
+)
+
 type JsonGateway struct {
-	mu      sync.RWMutex
-	logger  *zap.Logger
-	config  *config.Configuration
-	stores  map[uint32]*Store
-	LatMap  map[string][]uint32
-	LongMap map[string][]uint32
-	count   int
-	ready   bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.RWMutex
+	loadMu   sync.Mutex
+	writeMu  sync.Mutex
+	logger   *zap.Logger
+	config   *config.Configuration
+	geocoder geocoder.Geocoder
+	storage  Storage
+	data     *dataset
+	version  uint64
+	stamps   map[string]fileStamp
+	ready    bool
+	loadErr  error
+
+	// last geocoder reachability check, reused for GEOCODER_CHECK_TTL
+	checkMu   sync.Mutex
+	checkedAt time.Time
+	checkErr  error
 }
 
 type GatewayStats struct {
-	Count     int
-	LatCount  int
-	LongCount int
-	Ready     bool
+	Count        int           `json:"count"`
+	CellCount    int           `json:"cellCount"`
+	Ready        bool          `json:"ready"`
+	CacheHits    uint64        `json:"cacheHits"`
+	CacheMisses  uint64        `json:"cacheMisses"`
+	Version      uint64        `json:"version"`
+	LoadedAt     time.Time     `json:"loadedAt"`
+	LoadDuration time.Duration `json:"loadDuration"`
+	LoadError    string        `json:"loadError,omitempty"`
+}
+
+// MarshalJSON renders the load duration as a duration string, e.g. "1.5s"
+func (s GatewayStats) MarshalJSON() ([]byte, error) {
+	type stats GatewayStats
+	return json.Marshal(struct {
+		stats
+		LoadDuration string `json:"loadDuration"`
+	}{stats(s), s.LoadDuration.String()})
 }
 
-func NewJasonGateway(config *config.Configuration, logger *zap.Logger) *JsonGateway {
+// Readiness reports whether the gateway is ready to serve, along with the outcome of each check
+type Readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewJasonGateway creates a json file backed gateway, postal code search is disabled when geocoder is nil.
+// With a storage, stores and writes to them are persisted and survive restarts,
+// without one writes are held in memory only.
+func NewJasonGateway(config *config.Configuration, geocoder geocoder.Geocoder, storage Storage, logger *zap.Logger) *JsonGateway {
+	ctx, cancel := context.WithCancel(context.Background())
 	jg := &JsonGateway{
-		config:  config,
-		logger:  logger,
-		stores:  map[uint32]*Store{},
-		LatMap:  map[string][]uint32{},
-		LongMap: map[string][]uint32{},
-		count:   0,
-		ready:   false,
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   config,
+		geocoder: geocoder,
+		storage:  storage,
+		logger:   logger,
+		data:     newDataset(),
+		ready:    false,
 	}
 
 	return jg
 }
 
-func (jg *JsonGateway) ProcessFile() {
+// ProcessFile loads store data into a fresh dataset and swaps it in, blocking until done.
+// Stores are rebuilt from storage when it holds any, otherwise they are read from
+// data files and imported into storage. The current dataset is kept if loading fails
+// or is cancelled, through given context or by closing the gateway.
+func (jg *JsonGateway) ProcessFile(ctx context.Context) error {
+	jg.loadMu.Lock()
+	defer jg.loadMu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(jg.ctx, cancel)
+	defer stop()
+
+	start := time.Now()
+	if jg.storage != nil && jg.storage.Len() > 0 {
+		err := jg.restore(ctx)
+		jg.finishLoad(metrics.STORAGE_SOURCE, start, err)
+		return err
+	}
+	err := jg.load(ctx)
+	jg.finishLoad(metrics.FILES_SOURCE, start, err)
+	return err
+}
+
+// Reload loads store data files in the background and swaps the fresh dataset in once loaded.
+// With a storage, stores written through the gateway stay applied over the data file
+// contents, without one they are replaced. Returns ErrLoadInProgress if a load is already running.
+func (jg *JsonGateway) Reload() error {
+	if !jg.loadMu.TryLock() {
+		return ErrLoadInProgress
+	}
+
+	go func() {
+		defer jg.loadMu.Unlock()
+		jg.logger.Info("reloading store data")
+		start := time.Now()
+		err := jg.load(jg.ctx)
+		if err != nil {
+			jg.logger.Error("error reloading store data, keeping current dataset", zap.Error(err))
+		}
+		jg.finishLoad(metrics.FILES_SOURCE, start, err)
+	}()
+	return nil
+}
+
+// Close cancels any store data load in progress, waits for it to stop
+// and prevents further loads
+func (jg *JsonGateway) Close() {
+	jg.cancel()
+	jg.loadMu.Lock()
+	defer jg.loadMu.Unlock()
+}
+
+// WatchDataFiles polls data files at given interval and reloads store data when any of them changes,
+// until context is done
+func (jg *JsonGateway) WatchDataFiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	jg.logger.Info("watching store data files", zap.Duration("interval", interval))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := statFiles(jg.config.DataFilePaths())
+			jg.mu.RLock()
+			changed := !stampsEqual(jg.stamps, current)
+			jg.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			jg.logger.Info("store data files changed")
+			if err := jg.Reload(); err != nil {
+				jg.logger.Info("skipping store data reload", zap.Error(err))
+			}
+		}
+	}
+}
+
+// load reads data files into a new dataset and swaps it in, unless context is done first.
+// Writes wait for the swap, so none land on the replaced dataset. Callers must hold loadMu.
+func (jg *JsonGateway) load(parent context.Context) error {
 	defer func() {
 		jg.logger.Info("finished setting up store data")
 	}()
+	jg.writeMu.Lock()
+	defer jg.writeMu.Unlock()
+
+	start := time.Now()
+	filePaths := jg.config.DataFilePaths()
+	stamps := statFiles(filePaths)
+	jg.mu.Lock()
+	jg.stamps = stamps
+	jg.mu.Unlock()
+
+	ctx, span := tracer.Start(parent, "ProcessFile", trace.WithAttributes(
+		attribute.StringSlice("store.data_files", filePaths),
+		attribute.String("store.data_format", jg.config.DataFormat),
+	))
+	defer span.End()
 
-	ctx := context.WithValue(context.Background(), constants.FileNameContextKey, "locations.json")
+	ctx = context.WithValue(ctx, constants.DataFilesContextKey, filePaths)
+	ctx = context.WithValue(ctx, constants.DataFormatContextKey, jg.config.DataFormat)
 	ctx = context.WithValue(ctx, constants.ReadRateContextKey, 2)
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	ds := newDataset()
 	cout := make(chan *Store)
 	var wgp sync.WaitGroup
 	var wgs sync.WaitGroup
@@ -74,133 +270,623 @@ func (jg *JsonGateway) ProcessFile() {
 	wgp.Add(1)
 	go jg.readFile(ctx, cancel, &wgp, &wgs, cout)
 	wgp.Add(1)
-	go jg.processStore(ctx, cancel, &wgp, &wgs, cout)
+	go jg.processStore(ctx, cancel, &wgp, &wgs, ds, cout)
+	wgp.Wait()
+
+	if err := parent.Err(); err != nil {
+		jg.logger.Info("store data load cancelled", zap.Strings("filePaths", filePaths))
+		err = fmt.Errorf("store data load cancelled: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	// pipeline stages cancel the context on failure
+	if ctx.Err() != nil {
+		jg.logger.Error("store data load failed", zap.Strings("filePaths", filePaths))
+		err := fmt.Errorf("error loading store data from %v", filePaths)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	// an empty dataset would be served as loaded, every search coming back empty
+	if len(ds.stores) == 0 {
+		jg.logger.Error("no valid stores in store data files", zap.Strings("filePaths", filePaths))
+		err := fmt.Errorf("%w %v", ErrNoStores, filePaths)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
 
-	func() {
-		wgp.Wait()
-		jg.ready = true
-		stats := jg.GetStoreStats()
-		jg.logger.Info("gateway status", zap.Any("stats", stats))
-	}()
+	if jg.storage != nil {
+		if err := jg.importStores(ds); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+	}
+	jg.swap(ds, start)
+	span.SetAttributes(attribute.Int("store.count", len(ds.stores)))
+	return nil
 }
 
-func (jg *JsonGateway) GetStore(storeId uint32) (*Store, error) {
+// restore rebuilds the dataset from storage and swaps it in. Callers must hold loadMu.
+func (jg *JsonGateway) restore(ctx context.Context) error {
+	start := time.Now()
+	// data files are already reflected in storage, only later changes to them trigger reloads
+	stamps := statFiles(jg.config.DataFilePaths())
+	jg.mu.Lock()
+	jg.stamps = stamps
+	jg.mu.Unlock()
+
+	_, span := tracer.Start(ctx, "RestoreStores")
+	defer span.End()
+
+	jg.writeMu.Lock()
+	defer jg.writeMu.Unlock()
+
+	ds := newDataset()
+	err := jg.storage.ForEach(func(id uint32, value []byte) error {
+		var s Store
+		if err := json.Unmarshal(value, &s); err != nil {
+			return apperrors.WrapError(err, "error decoding stored store %d", id)
+		}
+		if !ds.add(&s) {
+			jg.logger.Error("duplicate stored store", zap.Uint32("storeId", id))
+			metrics.LoadRecordErrors.WithLabelValues(metrics.DUPLICATE_RECORD).Inc()
+		}
+		return nil
+	})
+	if err != nil {
+		jg.logger.Error("error restoring stores from storage", zap.Error(err))
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetAttributes(attribute.Int("store.count", len(ds.stores)))
+	jg.logger.Info("restored stores from storage", zap.Int("storeCount", len(ds.stores)))
+	jg.swap(ds, start)
+	return nil
+}
+
+// importStores rebases stores in storage on the ones in given dataset, then applies
+// the stores written to storage over the dataset. Callers must hold writeMu.
+func (jg *JsonGateway) importStores(ds *dataset) error {
+	values := make(map[uint32][]byte, len(ds.stores))
+	for id, s := range ds.stores {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return apperrors.WrapError(err, "error encoding store %d", id)
+		}
+		values[id] = data
+	}
+	if err := jg.storage.Rebase(values); err != nil {
+		jg.logger.Error("error importing stores into storage, keeping current dataset", zap.Error(err))
+		return err
+	}
+
+	writes := 0
+	err := jg.storage.ForEachWrite(func(id uint32, value []byte) error {
+		writes++
+		if value == nil {
+			ds.remove(id)
+			return nil
+		}
+		var s Store
+		if err := json.Unmarshal(value, &s); err != nil {
+			return apperrors.WrapError(err, "error decoding stored store %d", id)
+		}
+		ds.put(&s)
+		return nil
+	})
+	if err != nil {
+		jg.logger.Error("error applying stored writes, keeping current dataset", zap.Error(err))
+		return err
+	}
+	jg.logger.Info("imported stores into storage", zap.Int("storeCount", len(values)), zap.Int("writeCount", writes))
+	return nil
+}
+
+// swap makes given dataset the current one. Callers must hold writeMu.
+func (jg *JsonGateway) swap(ds *dataset, start time.Time) {
+	jg.mu.Lock()
+	jg.version++
+	ds.version = jg.version
+	ds.loadedAt = time.Now()
+	ds.loadDuration = ds.loadedAt.Sub(start)
+	jg.data = ds
+	jg.ready = true
+	jg.mu.Unlock()
+
+	stats := jg.GetStoreStats()
+	jg.logger.Info("gateway status", zap.Any("stats", stats))
+}
+
+// persist writes store to storage, if any, before it's applied in memory. Callers must hold writeMu.
+func (jg *JsonGateway) persist(ctx context.Context, s *Store) error {
+	logger := logging.FromContext(ctx, jg.logger)
+	if jg.storage == nil {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return apperrors.WrapError(err, "error encoding store %d", s.Id)
+	}
+	if err = jg.storage.Put(s.Id, data); err != nil {
+		logger.Error("error persisting store", zap.Error(err), zap.Uint32("storeId", s.Id))
+		return err
+	}
+	return nil
+}
+
+// finishLoad records the outcome of a load started at start
+func (jg *JsonGateway) finishLoad(source string, start time.Time, err error) {
+	outcome := metrics.LOAD_SUCCESS
+	if err != nil {
+		outcome = metrics.LOAD_FAILURE
+	}
+	metrics.LoadDuration.WithLabelValues(source, outcome).Observe(time.Since(start).Seconds())
+
+	jg.mu.Lock()
+	defer jg.mu.Unlock()
+
+	jg.loadErr = err
+}
+
+// snapshot returns the current dataset
+func (jg *JsonGateway) snapshot() *dataset {
 	jg.mu.RLock()
 	defer jg.mu.RUnlock()
 
-	s := jg.lookup(storeId)
+	return jg.data
+}
+
+func (jg *JsonGateway) GetStore(ctx context.Context, storeId uint32) (*Store, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	s := ds.lookup(storeId)
 	if s == nil {
-		jg.logger.Error("store doesn't exist", zap.Int("storeId", int(storeId)))
-		return nil, fmt.Errorf("store with storeId %d doesn't exist", storeId)
+		logger.Error("store doesn't exist", zap.Int("storeId", int(storeId)))
+		return nil, fmt.Errorf("%w: storeId %d", ErrStoreNotFound, storeId)
 	}
 	return s, nil
 }
 
-func (jg *JsonGateway) GetStoresForPostalCode(postalCode string, dist int) ([]*Store, error) {
-	url := fmt.Sprintf("https://maps.google.com/maps/api/geocode/json?components=country:US|postal_code:%s&sensor=false&key=%s", postalCode, jg.config.GEOCODER_API_KEY)
+// GetStores returns the stores for given ids in requested order, along with the ids that don't exist
+func (jg *JsonGateway) GetStores(ctx context.Context, storeIds []uint32) ([]*Store, []uint32) {
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
 
-	r, err := http.Get(url)
-	if err != nil {
-		jg.logger.Error("geocoder request error", zap.Error(err), zap.String("postalCode", postalCode))
+	stores := []*Store{}
+	missing := []uint32{}
+	seen := make(map[uint32]bool, len(storeIds))
+	for _, id := range storeIds {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if s := ds.lookup(id); s != nil {
+			stores = append(stores, s)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return stores, missing
+}
+
+// AddStore validates and adds a new store to the current dataset, persisting it first when storage is set.
+// Without storage, reloading data files replaces written stores.
+func (jg *JsonGateway) AddStore(ctx context.Context, s *Store) (*Store, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	if err := s.Validate(); err != nil {
 		return nil, err
 	}
-	defer r.Body.Close()
+	ns := *s
+	if ns.Created.IsZero() {
+		ns.Created = time.Now().UTC()
+	}
 
-	var results GeocoderResults
-	err = json.NewDecoder(r.Body).Decode(&results)
-	if err != nil {
-		jg.logger.Error("error decoding geocode response", zap.Error(err), zap.String("postalCode", postalCode))
+	jg.writeMu.Lock()
+	defer jg.writeMu.Unlock()
+
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	exists := ds.lookup(ns.Id) != nil
+	ds.mu.RUnlock()
+	if exists {
+		return nil, fmt.Errorf("%w: storeId %d", ErrStoreExists, s.Id)
+	}
+	if err := jg.persist(ctx, &ns); err != nil {
 		return nil, err
 	}
+	ds.add(&ns)
+	logger.Info("added store", zap.Uint32("storeId", ns.Id))
+	return &ns, nil
+}
 
-	if strings.ToUpper(results.Status) != "OK" {
-		// If the status is not "OK" check what status was returned
-		switch strings.ToUpper(results.Status) {
-		case "ZERO_RESULTS":
-			err = errors.New("no results found")
-		case "OVER_QUERY_LIMIT":
-			err = errors.New("over quota request")
-		case "REQUEST_DENIED":
-			err = errors.New("request was denied")
-		case "INVALID_REQUEST":
-			err = errors.New("invalid request")
-		case "UNKNOWN_ERROR":
-			err = errors.New("server error, please, try again")
-		default:
-			err = errors.New("unknown error")
-		}
-		jg.logger.Error("geocode response error", zap.Error(err), zap.String("postalCode", postalCode))
+// UpdateStore validates and replaces an existing store, re-indexing it if it moved
+func (jg *JsonGateway) UpdateStore(ctx context.Context, s *Store) (*Store, error) {
+	if err := s.Validate(); err != nil {
 		return nil, err
 	}
-	lat, long := results.Results[0].Geometry.Location.Lat, results.Results[0].Geometry.Location.Lng
-	jg.logger.Debug("geocoder geopoint response", zap.Float64("latitude", lat), zap.Float64("longitude", long))
 
-	return jg.GetStoresForGeoPoint(lat, long, dist)
+	jg.writeMu.Lock()
+	defer jg.writeMu.Unlock()
+
+	return jg.replaceStore(ctx, s.Id, func(old *Store) *Store {
+		ns := *s
+		if ns.Created.IsZero() {
+			ns.Created = old.Created
+		}
+		return &ns
+	})
 }
 
-func (jg *JsonGateway) GetStoresForGeoPoint(lat, long float64, dist int) ([]*Store, error) {
-	jg.mu.RLock()
-	defer jg.mu.RUnlock()
+// PatchStore applies a partial update to an existing store, re-indexing it if it moved
+func (jg *JsonGateway) PatchStore(ctx context.Context, storeId uint32, patch *StorePatch) (*Store, error) {
+	jg.writeMu.Lock()
+	defer jg.writeMu.Unlock()
 
-	jg.logger.Debug("getting stores for geopoint", zap.Float64("latitude", lat), zap.Float64("longitude", long), zap.Int("distance", dist))
-	latKey := buildMapKey(lat)
-	latStoreIDs, ok := jg.LatMap[latKey]
-	if !ok {
-		jg.logger.Error("no stores found for latitude", zap.Float64("latitude", lat))
-		return nil, fmt.Errorf("no stores found for lat: %f", lat)
+	return jg.replaceStore(ctx, storeId, patch.apply)
+}
+
+// DeleteStore removes a store from the current dataset and all its indexes
+func (jg *JsonGateway) DeleteStore(ctx context.Context, storeId uint32) error {
+	logger := logging.FromContext(ctx, jg.logger)
+	jg.writeMu.Lock()
+	defer jg.writeMu.Unlock()
+
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	exists := ds.lookup(storeId) != nil
+	ds.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("%w: storeId %d", ErrStoreNotFound, storeId)
 	}
+	if jg.storage != nil {
+		if err := jg.storage.Delete(storeId); err != nil {
+			logger.Error("error deleting persisted store", zap.Error(err), zap.Uint32("storeId", storeId))
+			return err
+		}
+	}
+	ds.remove(storeId)
+	logger.Info("deleted store", zap.Uint32("storeId", storeId))
+	return nil
+}
 
-	longKey := buildMapKey(long)
-	longStoreIDs, ok := jg.LongMap[longKey]
-	if !ok {
-		jg.logger.Error("no stores found for longitude", zap.Float64("longitude", long))
-		return nil, fmt.Errorf("no stores found for long: %f", long)
+// replaceStore swaps an existing store for the validated result of update.
+// Callers must hold the write lock.
+func (jg *JsonGateway) replaceStore(ctx context.Context, storeId uint32, update func(old *Store) *Store) (*Store, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	old := ds.lookup(storeId)
+	ds.mu.RUnlock()
+	if old == nil {
+		return nil, fmt.Errorf("%w: storeId %d", ErrStoreNotFound, storeId)
+	}
+
+	ns := update(old)
+	if err := ns.Validate(); err != nil {
+		return nil, err
+	}
+	if err := jg.persist(ctx, ns); err != nil {
+		return nil, err
+	}
+	ds.put(ns)
+	logger.Info("updated store", zap.Uint32("storeId", storeId))
+	return ns, nil
+}
+
+// GetStoresForPostalCode geocodes given postal code and returns the resolved origin
+// along with stores within dist km of it matching filter, sorted nearest first.
+// Postal codes failing geocoder.ValidatePostalCode are rejected before geocoding.
+func (jg *JsonGateway) GetStoresForPostalCode(ctx context.Context, postalCode string, dist int, filter *StoreFilter) (*GeoPoint, []*StoreResult, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	if err := geocoder.ValidatePostalCode(postalCode); err != nil {
+		return nil, nil, err
+	}
+	if jg.geocoder == nil {
+		logger.Error("geocoder not configured", zap.String("postalCode", postalCode))
+		return nil, nil, ErrPostalSearchUnavailable
+	}
+
+	gctx, span := tracer.Start(ctx, "Geocode", trace.WithAttributes(attribute.String("geocoder.postal_code", postalCode)))
+	lat, long, err := jg.geocoder.Geocode(gctx, postalCode)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		logger.Error("error geocoding postal code", zap.Error(err), zap.String("postalCode", postalCode))
+		return nil, nil, err
+	}
+	span.End()
+
+	stores, err := jg.GetStoresForGeoPoint(ctx, lat, long, dist, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &GeoPoint{Latitude: lat, Longitude: long}, stores, nil
+}
+
+// GetStoresForGeoPoint returns stores within dist km of given point matching filter,
+// sorted nearest first. A nil filter matches any store.
+func (jg *JsonGateway) GetStoresForGeoPoint(ctx context.Context, lat, long float64, dist int, filter *StoreFilter) ([]*StoreResult, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	filter = filter.normalize()
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	logger.Debug("getting stores for geopoint", zap.Float64("latitude", lat), zap.Float64("longitude", long), zap.Int("distance", dist))
+	ctx, span := tracer.Start(ctx, "SpatialLookup", trace.WithAttributes(
+		attribute.Float64("geo.latitude", lat),
+		attribute.Float64("geo.longitude", long),
+		attribute.Int("search.distance_km", dist),
+		attribute.Bool("search.filtered", filter != nil),
+	))
+	defer span.End()
+	ids := ds.index.searchRadius(lat, long, float64(dist))
+	// a selective filter narrows candidates down faster than the search circle
+	if filter.indexed() && ds.candidateCount(filter) < len(ids) {
+		ids, _ = ds.candidates(filter)
+	}
+	logger.Debug("found stores", zap.Int("numOfStores", len(ids)), zap.Float64("latitude", lat), zap.Float64("longitude", long))
+	results, err := ds.storesWithin(ctx, lat, long, float64(dist), ids, filter)
+	if err != nil {
+		logger.Info("stopped getting stores for geopoint", zap.Error(err), zap.Float64("latitude", lat), zap.Float64("longitude", long))
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
+	span.SetAttributes(attribute.Int("search.stores_examined", len(ids)), attribute.Int("search.stores_returned", len(results)))
+	metrics.StoresExamined.WithLabelValues(metrics.GEOPOINT_SEARCH).Observe(float64(len(ids)))
+	metrics.StoresReturned.WithLabelValues(metrics.GEOPOINT_SEARCH).Observe(float64(len(results)))
+	logger.Debug("returning stores", zap.Int("numOfStores", len(results)), zap.Float64("latitude", lat), zap.Float64("longitude", long), zap.Int("distance", dist))
+	return results, nil
+}
 
-	m := make(map[uint32]bool)
-	ids := []uint32{}
-	for _, item := range latStoreIDs {
-		m[item] = true
-		ids = append(ids, item)
+// GetNearestStores returns upto k stores closest to given point, sorted nearest first
+func (jg *JsonGateway) GetNearestStores(ctx context.Context, lat, long float64, k int) ([]*StoreResult, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	if k <= 0 {
+		return nil, fmt.Errorf("invalid number of stores requested: %d", k)
 	}
 
-	for _, item := range longStoreIDs {
-		if _, ok := m[item]; !ok {
-			ids = append(ids, item)
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	logger.Debug("getting nearest stores for geopoint", zap.Float64("latitude", lat), zap.Float64("longitude", long), zap.Int("count", k))
+	ctx, span := tracer.Start(ctx, "SpatialLookup", trace.WithAttributes(
+		attribute.Float64("geo.latitude", lat),
+		attribute.Float64("geo.longitude", long),
+		attribute.Int("search.count", k),
+	))
+	defer span.End()
+	var results []*StoreResult
+	examined := 0
+	// widen the search circle until it holds k stores or covers the globe
+	for dist := NEAREST_START_DISTANCE_KM; ; dist *= 2 {
+		if dist > MAX_SEARCH_DISTANCE_KM {
+			dist = MAX_SEARCH_DISTANCE_KM
+		}
+		ids := ds.index.searchRadius(lat, long, dist)
+		examined += len(ids)
+		var err error
+		results, err = ds.storesWithin(ctx, lat, long, dist, ids, nil)
+		if err != nil {
+			logger.Info("stopped getting nearest stores", zap.Error(err), zap.Float64("latitude", lat), zap.Float64("longitude", long))
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		if len(results) >= k || dist >= MAX_SEARCH_DISTANCE_KM {
+			break
 		}
 	}
-	jg.logger.Debug("found stores", zap.Int("numOfStores", len(ids)), zap.Float64("latitude", lat), zap.Float64("longitude", long))
+
+	if len(results) > k {
+		results = results[:k]
+	}
+	span.SetAttributes(attribute.Int("search.stores_examined", examined), attribute.Int("search.stores_returned", len(results)))
+	metrics.StoresExamined.WithLabelValues(metrics.NEAREST_SEARCH).Observe(float64(examined))
+	metrics.StoresReturned.WithLabelValues(metrics.NEAREST_SEARCH).Observe(float64(len(results)))
+	logger.Debug("returning nearest stores", zap.Int("numOfStores", len(results)), zap.Float64("latitude", lat), zap.Float64("longitude", long))
+	return results, nil
+}
+
+// FindStores returns upto limit stores matching filter, ordered by id, along with
+// the total number of matches. Stores are looked up by country or city, one of
+// which is required, so no query scans all stores.
+func (jg *JsonGateway) FindStores(ctx context.Context, filter *StoreFilter, limit int) ([]*Store, int, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	if limit <= 0 {
+		return nil, 0, fmt.Errorf("invalid number of stores requested: %d", limit)
+	}
+	filter = filter.normalize()
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	ids, ok := ds.candidates(filter)
+	if !ok {
+		return nil, 0, ErrFilterRequired
+	}
+	_, span := tracer.Start(ctx, "AttributeLookup", trace.WithAttributes(attribute.Int("search.count", limit)))
+	defer span.End()
+
 	stores := []*Store{}
-	// origin := haversine.Point{Lat: lat, Lon: long}
-	origin := vincenty.LatLng{Latitude: lat, Longitude: long}
-	for _, v := range ids {
-		store, err := jg.GetStore(v)
-		if err != nil {
-			jg.logger.Error("no stores found for id", zap.Int("storeId", int(v)))
+	for i, id := range ids {
+		if i%CTX_CHECK_INTERVAL == 0 {
+			if err := ctx.Err(); err != nil {
+				logger.Info("stopped finding stores", zap.Error(err))
+				span.SetStatus(codes.Error, err.Error())
+				return nil, 0, err
+			}
+		}
+		if s := ds.lookup(id); s != nil && filter.matches(s) {
+			stores = append(stores, s)
 		}
-		// pos := haversine.Point{Lat: store.Latitude, Lon: store.Longitude}
-		pos := vincenty.LatLng{Latitude: store.Latitude, Longitude: store.Longitude}
-		// d := haversine.Distance(origin, pos)
-		d := vincenty.Inverse(origin, pos)
-		// if float64(d) <= dist*1000 {
-		if d.Kilometers() <= float64(dist) {
-			stores = append(stores, store)
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].Id < stores[j].Id
+	})
+	total := len(stores)
+	if total > limit {
+		stores = stores[:limit]
+	}
+	span.SetAttributes(attribute.Int("search.stores_examined", len(ids)), attribute.Int("search.stores_returned", len(stores)))
+	metrics.StoresExamined.WithLabelValues(metrics.ATTRIBUTE_SEARCH).Observe(float64(len(ids)))
+	metrics.StoresReturned.WithLabelValues(metrics.ATTRIBUTE_SEARCH).Observe(float64(len(stores)))
+	logger.Debug("returning stores", zap.Int("numOfStores", len(stores)), zap.Int("total", total))
+	return stores, total, nil
+}
+
+// SearchStores returns upto limit stores whose name or city match query text, best match
+// first. Query tokens match indexed terms exactly, as prefixes, or with a typo in longer
+// tokens, name matches rank above city matches. Stores near origin, when given, rank higher.
+func (jg *JsonGateway) SearchStores(ctx context.Context, query string, origin *GeoPoint, limit int) ([]*TextResult, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid number of stores requested: %d", limit)
+	}
+	tokens := []string{}
+	for _, t := range tokenize(query) {
+		if !slices.Contains(tokens, t) {
+			tokens = append(tokens, t)
 		}
 	}
-	jg.logger.Debug("returning stores", zap.Int("numOfStores", len(stores)), zap.Float64("latitude", lat), zap.Float64("longitude", long), zap.Int("distance", dist))
-	return stores, nil
+	if len(tokens) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	logger.Debug("searching stores", zap.String("query", query), zap.Strings("tokens", tokens))
+	ctx, span := tracer.Start(ctx, "TextLookup", trace.WithAttributes(
+		attribute.Int("search.tokens", len(tokens)),
+		attribute.Bool("search.geo_boost", origin != nil),
+		attribute.Int("search.count", limit),
+	))
+	defer span.End()
+	results, err := ds.searchText(ctx, tokens, origin)
+	if err != nil {
+		logger.Info("stopped searching stores", zap.Error(err), zap.String("query", query))
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	examined := len(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	span.SetAttributes(attribute.Int("search.stores_examined", examined), attribute.Int("search.stores_returned", len(results)))
+	metrics.StoresExamined.WithLabelValues(metrics.TEXT_SEARCH).Observe(float64(examined))
+	metrics.StoresReturned.WithLabelValues(metrics.TEXT_SEARCH).Observe(float64(len(results)))
+	logger.Debug("returning stores", zap.Int("numOfStores", len(results)), zap.String("query", query))
+	return results, nil
+}
+
+// ExportNDJSON writes all stores, ordered by id, as newline delimited json
+// that can be loaded back as an ndjson data file. Returns number of stores written.
+func (jg *JsonGateway) ExportNDJSON(ctx context.Context, w io.Writer) (int, error) {
+	logger := logging.FromContext(ctx, jg.logger)
+	ds := jg.snapshot()
+	ds.mu.RLock()
+	stores := make([]*Store, 0, len(ds.stores))
+	for _, s := range ds.stores {
+		stores = append(stores, s)
+	}
+	ds.mu.RUnlock()
+
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].Id < stores[j].Id
+	})
+	if err := loader.WriteNDJSON(w, stores); err != nil {
+		logger.Error("error exporting stores", zap.Error(err))
+		return 0, err
+	}
+	return len(stores), nil
 }
 
 func (jg *JsonGateway) GetStoreStats() GatewayStats {
 	jg.mu.RLock()
-	defer jg.mu.RUnlock()
+	ds, ready, loadErr := jg.data, jg.ready, jg.loadErr
+	jg.mu.RUnlock()
+
+	ds.mu.RLock()
+	stats := GatewayStats{
+		Ready:        ready,
+		Count:        len(ds.stores),
+		CellCount:    ds.index.len(),
+		Version:      ds.version,
+		LoadedAt:     ds.loadedAt,
+		LoadDuration: ds.loadDuration,
+	}
+	ds.mu.RUnlock()
+	if loadErr != nil {
+		stats.LoadError = loadErr.Error()
+	}
+	if sr, ok := jg.geocoder.(geocoder.StatsReporter); ok {
+		cs := sr.Stats()
+		stats.CacheHits, stats.CacheMisses = cs.Hits, cs.Misses
+	}
+	return stats
+}
+
+// CheckReadiness checks store data has been loaded and the geocoder, when configured,
+// is reachable. The outcome of the last load is reported, a failed load fails readiness
+// until a load succeeds but a failed reload keeps serving the data loaded before it.
+func (jg *JsonGateway) CheckReadiness(ctx context.Context) Readiness {
+	stats := jg.GetStoreStats()
+	r := Readiness{Ready: true, Checks: map[string]string{}}
+
+	r.Checks["data"] = CHECK_OK
+	if !stats.Ready {
+		r.Ready = false
+		r.Checks["data"] = CHECK_NOT_LOADED
+	}
+	r.Checks["load"] = CHECK_OK
+	if stats.LoadError != "" {
+		r.Checks["load"] = stats.LoadError
+		if !stats.Ready {
+			r.Ready = false
+		}
+	}
+
+	switch c, ok := jg.geocoder.(geocoder.Checker); {
+	case jg.geocoder == nil:
+		r.Checks["geocoder"] = CHECK_NOT_CONFIGURED
+	case ok:
+		r.Checks["geocoder"] = CHECK_OK
+		if err := jg.checkGeocoder(ctx, c); err != nil {
+			r.Ready = false
+			r.Checks["geocoder"] = err.Error()
+		}
+	default:
+		r.Checks["geocoder"] = CHECK_OK
+	}
+	return r
+}
 
-	return GatewayStats{
-		Ready:     jg.ready,
-		Count:     jg.count,
-		LatCount:  len(jg.LatMap),
-		LongCount: len(jg.LongMap),
+// checkGeocoder checks the geocoder is reachable, reusing the outcome
+// of the last check made within GEOCODER_CHECK_TTL
+func (jg *JsonGateway) checkGeocoder(ctx context.Context, c geocoder.Checker) error {
+	jg.checkMu.Lock()
+	defer jg.checkMu.Unlock()
+
+	if !jg.checkedAt.IsZero() && time.Since(jg.checkedAt) < GEOCODER_CHECK_TTL {
+		return jg.checkErr
+	}
+	cctx, cancel := context.WithTimeout(ctx, GEOCODER_CHECK_TIMEOUT)
+	defer cancel()
+	err := c.Check(cctx)
+	// a check cut short by its caller says nothing about the geocoder
+	if ctx.Err() != nil {
+		return err
 	}
+	if err != nil {
+		jg.logger.Error("geocoder is unreachable", zap.Error(err))
+	}
+	jg.checkedAt, jg.checkErr = time.Now(), err
+	return err
 }
 
 func (jg *JsonGateway) readFile(
@@ -210,44 +896,78 @@ func (jg *JsonGateway) readFile(
 	wgs *sync.WaitGroup,
 	out chan *Store,
 ) {
-	jg.logger.Info("start reading store data file")
-	fileName := ctx.Value(constants.FileNameContextKey).(string)
-	resultStream, err := loader.ReadFileArray(ctx, cancel, fileName)
+	defer wgp.Done()
+	defer close(out)
+
+	filePaths := ctx.Value(constants.DataFilesContextKey).([]string)
+	format := ctx.Value(constants.DataFormatContextKey).(string)
+	for _, filePath := range filePaths {
+		if !jg.readDataFile(ctx, cancel, wgs, filePath, format, out) {
+			return
+		}
+	}
+}
+
+// readDataFile publishes stores read from given data file, returns false if reading was cancelled
+func (jg *JsonGateway) readDataFile(
+	ctx context.Context,
+	cancel func(),
+	wgs *sync.WaitGroup,
+	filePath, format string,
+	out chan *Store,
+) bool {
+	jg.logger.Info("start reading store data file", zap.String("filePath", filePath))
+	_, span := tracer.Start(ctx, "ReadDataFile", trace.WithAttributes(attribute.String("store.data_file", filePath)))
+	defer span.End()
+	resultStream, err := loader.ReadFile(ctx, cancel, filePath, format, loader.Options{
+		CSVMapping:     jg.config.CSVMapping,
+		GeoJSONMapping: jg.config.GeoJSONMapping,
+	})
 	if err != nil {
-		jg.logger.Error("error reading store data file", zap.Error(err))
+		jg.logger.Error("error reading store data file", zap.Error(err), zap.String("filePath", filePath))
+		span.SetStatus(codes.Error, err.Error())
 		cancel()
+		return false
 	}
 	count := 0
 
 	for {
 		select {
 		case <-ctx.Done():
-			jg.logger.Info("store data file read context done")
-			return
+			jg.logger.Info("store data file read context done", zap.String("filePath", filePath))
+			span.SetStatus(codes.Error, "store data load cancelled")
+			return false
 		case r, ok := <-resultStream:
 			if !ok {
-				jg.logger.Info("store data file result stream closed")
-				wgp.Done()
-				close(out)
-				return
+				jg.logger.Info("store data file result stream closed", zap.String("filePath", filePath), zap.Int("storeCount", count))
+				span.SetAttributes(attribute.Int("store.record_count", count))
+				return true
 			}
 			wgs.Add(1)
 			count++
-			// if count%1000 == 0 {
-			// 	jg.logger.Debug("publishing store", zap.Any("storeJson", r), zap.Int("storeCount", count))
-			// }
-			jg.publishStore(r, out)
+			jg.publishStore(ctx, wgs, r, out)
 		}
 	}
 }
 
-func (jg *JsonGateway) publishStore(r map[string]interface{}, out chan *Store) {
+func (jg *JsonGateway) publishStore(ctx context.Context, wgs *sync.WaitGroup, r map[string]interface{}, out chan *Store) {
 	store, err := mapResultToStore(r)
 	if err != nil {
 		jg.logger.Error("error processing store data", zap.Error(err), zap.Any("storeJson", r))
-	} else {
-		// jg.logger.Debug("publishing store", zap.Any("store", store))
-		out <- store
+		metrics.LoadRecordErrors.WithLabelValues(metrics.UNMAPPABLE_RECORD).Inc()
+		wgs.Done()
+		return
+	}
+	if err = validateRecord(r, store); err != nil {
+		jg.logger.Error("skipping invalid store record", zap.Error(err), zap.Any("storeJson", r))
+		metrics.LoadRecordErrors.WithLabelValues(metrics.INVALID_RECORD).Inc()
+		wgs.Done()
+		return
+	}
+	select {
+	case <-ctx.Done():
+		wgs.Done()
+	case out <- store:
 	}
 }
 
@@ -256,10 +976,18 @@ func (jg *JsonGateway) processStore(
 	cancel func(),
 	wgp *sync.WaitGroup,
 	wgs *sync.WaitGroup,
+	ds *dataset,
 	out chan *Store,
 ) {
+	defer wgp.Done()
+
 	jg.logger.Info("start updating store data")
+	_, span := tracer.Start(ctx, "ProcessStores")
+	defer span.End()
 	count := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("store.record_count", count))
+	}()
 
 	for {
 		select {
@@ -269,15 +997,15 @@ func (jg *JsonGateway) processStore(
 		case store, ok := <-out:
 			if !ok {
 				jg.logger.Info("store notification channel closed")
-				wgp.Done()
 				return
 			}
 			// if count%1000 == 0 {
 			// 	 jg.logger.Debug("processing store", zap.Any("store", store), zap.Int("storeCount", count))
 			// }
-			success := jg.updateDataStores(store)
+			success := ds.add(store)
 			if !success {
 				jg.logger.Error("error processing store data", zap.Any("store", store), zap.Int("storeCount", count))
+				metrics.LoadRecordErrors.WithLabelValues(metrics.DUPLICATE_RECORD).Inc()
 			}
 			count++
 			wgs.Done()
@@ -285,47 +1013,7 @@ func (jg *JsonGateway) processStore(
 	}
 }
 
+// updateDataStores adds a new store to the current dataset, returns false if store id already exists
 func (jg *JsonGateway) updateDataStores(s *Store) bool {
-	jg.mu.Lock()
-	defer jg.mu.Unlock()
-
-	if jg.lookup(s.Id) == nil {
-		latKey := buildMapKey(s.Latitude)
-		latStoreIDs, ok := jg.LatMap[latKey]
-		if !ok {
-			latStoreIDs = []uint32{}
-		}
-		latStoreIDs = append(latStoreIDs, s.Id)
-
-		longKey := buildMapKey(s.Longitude)
-		longStoreIDs, ok := jg.LongMap[longKey]
-		if !ok {
-			longStoreIDs = []uint32{}
-		}
-		longStoreIDs = append(longStoreIDs, s.Id)
-
-		jg.LatMap[latKey] = latStoreIDs
-		jg.LongMap[longKey] = longStoreIDs
-		jg.stores[s.Id] = s
-		jg.count++
-
-		return true
-	}
-	return false
-}
-
-func (jg *JsonGateway) lookup(k uint32) *Store {
-	v, ok := jg.stores[k]
-	if !ok {
-		return nil
-	}
-	return v
-}
-
-func buildMapKey(v float64) string {
-	le := math.Floor(v*10) / 10
-	te := le + 0.1
-	k := fmt.Sprintf("%.1f-%.1f", le, te)
-	// fmt.Println("buildMapKey() - map key: ", k)
-	return k
+	return jg.snapshot().add(s)
 }
@@ -0,0 +1,415 @@
+package listing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"github.com/hankgalt/starbucks/pkg/storage"
+	"go.uber.org/zap"
+)
+
+func TestExportNDJSONRoundTrip(t *testing.T) {
+	jg := NewJasonGateway(&config.Configuration{}, nil, nil, zap.NewNop())
+	stores := []*Store{
+		{Id: 8, Name: "Telford Plaza", City: "Kowloon", Country: "CN", Latitude: 22.3228702545166, Longitude: 114.21343994140625},
+		{Id: 1, Name: "Plaza Hollywood", City: "Hong Kong", Country: "CN", Latitude: 22.340700149536133, Longitude: 114.20169067382812},
+	}
+	for _, s := range stores {
+		jg.updateDataStores(s)
+	}
+
+	var buf bytes.Buffer
+	n, err := jg.ExportNDJSON(context.Background(), &buf)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 stores exported, got %d, %v", n, err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "snapshot.ndjson")
+	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+	if err := loaded.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var again bytes.Buffer
+	if _, err := loaded.ExportNDJSON(context.Background(), &again); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != again.String() {
+		t.Errorf("expected round trip to match\n%s\ngot\n%s", buf.String(), again.String())
+	}
+	for _, s := range stores {
+		got, err := loaded.GetStore(context.Background(), s.Id)
+		if err != nil || *got != *s {
+			t.Errorf("expected store %+v, got %+v, %v", s, got, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stores.ndjson")
+	write := func(data string) {
+		if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}` + "\n")
+
+	jg := NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+	if err := jg.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats := jg.GetStoreStats()
+	if !stats.Ready || stats.Count != 1 || stats.Version != 1 || stats.LoadedAt.IsZero() {
+		t.Fatalf("unexpected stats after initial load %+v", stats)
+	}
+
+	// a query in flight keeps its snapshot while a reload swaps in new data
+	old := jg.snapshot()
+	old.mu.RLock()
+
+	write(`{"store_id": 6, "name": "Exchange Square", "latitude": 22.2839, "longitude": 114.1581}` + "\n" +
+		`{"store_id": 8, "name": "Telford Plaza", "latitude": 22.3228, "longitude": 114.2134}` + "\n")
+	if err := jg.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := jg.Reload(); err != ErrLoadInProgress {
+		t.Errorf("expected load in progress error, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for jg.GetStoreStats().Version != 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for reload")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if old.lookup(1) == nil || len(old.stores) != 1 {
+		t.Errorf("expected old snapshot to be unchanged")
+	}
+	old.mu.RUnlock()
+
+	if _, err := jg.GetStore(context.Background(), 1); err == nil {
+		t.Errorf("expected store 1 to be gone after reload")
+	}
+	if stats := jg.GetStoreStats(); stats.Count != 2 {
+		t.Errorf("expected 2 stores after reload, got %+v", stats)
+	}
+
+	// a failed load keeps current data
+	os.Remove(filePath)
+	if err := jg.ProcessFile(context.Background()); err == nil {
+		t.Errorf("expected error loading missing file")
+	}
+	if stats := jg.GetStoreStats(); stats.Count != 2 || stats.Version != 2 {
+		t.Errorf("expected current data to be kept, got %+v", stats)
+	}
+}
+
+func TestStoreWrites(t *testing.T) {
+	jg := NewJasonGateway(&config.Configuration{}, nil, nil, zap.NewNop())
+	store := &Store{Id: 1, Name: "Plaza Hollywood", City: "Hong Kong", Country: "CN", Latitude: 22.3407, Longitude: 114.2016}
+
+	if _, err := jg.AddStore(context.Background(), &Store{Id: 2, Name: "Nowhere", Latitude: 91}); !errors.Is(err, ErrInvalidStore) {
+		t.Errorf("expected invalid store error, got %v", err)
+	}
+	added, err := jg.AddStore(context.Background(), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if added.Created.IsZero() {
+		t.Errorf("expected created time to be set")
+	}
+	if _, err = jg.AddStore(context.Background(), store); !errors.Is(err, ErrStoreExists) {
+		t.Errorf("expected store exists error, got %v", err)
+	}
+
+	// moving a store re-indexes it
+	lat, long := 40.7484, -73.9967
+	patched, err := jg.PatchStore(context.Background(), 1, &StorePatch{Latitude: &lat, Longitude: &long})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patched.Name != store.Name || patched.Created != added.Created {
+		t.Errorf("expected unpatched fields to be kept, got %+v", patched)
+	}
+	if res, _ := jg.GetStoresForGeoPoint(context.Background(), store.Latitude, store.Longitude, 10, nil); len(res) != 0 {
+		t.Errorf("expected no stores at old location, got %d", len(res))
+	}
+	if res, _ := jg.GetStoresForGeoPoint(context.Background(), lat, long, 10, nil); len(res) != 1 {
+		t.Errorf("expected store at new location, got %d", len(res))
+	}
+
+	bad := 200.0
+	if _, err = jg.PatchStore(context.Background(), 1, &StorePatch{Longitude: &bad}); !errors.Is(err, ErrInvalidStore) {
+		t.Errorf("expected invalid store error, got %v", err)
+	}
+	if _, err = jg.UpdateStore(context.Background(), &Store{Id: 5, Name: "Missing"}); !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("expected store not found error, got %v", err)
+	}
+	updated, err := jg.UpdateStore(context.Background(), &Store{Id: 1, Name: "Renamed", Latitude: lat, Longitude: long})
+	if err != nil || updated.Name != "Renamed" || updated.City != "" {
+		t.Errorf("expected store to be replaced, got %+v, %v", updated, err)
+	}
+
+	if err = jg.DeleteStore(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = jg.DeleteStore(context.Background(), 1); !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("expected store not found error, got %v", err)
+	}
+	if res, _ := jg.GetNearestStores(context.Background(), lat, long, 1); len(res) != 0 {
+		t.Errorf("expected deleted store to be gone from index, got %d", len(res))
+	}
+	if stats := jg.GetStoreStats(); stats.Count != 0 || stats.CellCount != 0 {
+		t.Errorf("expected empty dataset, got %+v", stats)
+	}
+}
+
+func TestPersistentStores(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stores.ndjson")
+	data := `{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}` + "\n" +
+		`{"store_id": 6, "name": "Exchange Square", "latitude": 22.2839, "longitude": 114.1581}` + "\n"
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Configuration{DataFiles: []string{filePath}}
+	dir := t.TempDir()
+
+	st, err := storage.NewFileStorage(dir, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jg := NewJasonGateway(cfg, nil, st, zap.NewNop())
+	if err := jg.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.Len() != 2 {
+		t.Fatalf("expected data file stores to be imported, got %d", st.Len())
+	}
+	if _, err := jg.AddStore(context.Background(), &Store{Id: 8, Name: "Telford Plaza", Latitude: 22.3228, Longitude: 114.2134}); err != nil {
+		t.Fatal(err)
+	}
+	name := "Plaza Hollywood, Diamond Hill"
+	if _, err := jg.PatchStore(context.Background(), 1, &StorePatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jg.DeleteStore(context.Background(), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart without closing storage, as after a crash
+	st, err = storage.NewFileStorage(dir, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	restarted := NewJasonGateway(cfg, nil, st, zap.NewNop())
+	if err := restarted.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, err := restarted.GetStore(context.Background(), 1); err != nil || s.Name != name {
+		t.Errorf("expected patched store 1, got %+v, %v", s, err)
+	}
+	if _, err := restarted.GetStore(context.Background(), 6); !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("expected deleted store 6 to stay deleted, got %v", err)
+	}
+	if results, _ := restarted.GetStoresForGeoPoint(context.Background(), 22.3228, 114.2134, 1, nil); len(results) != 1 || results[0].Id != 8 {
+		t.Errorf("expected index rebuilt with added store 8, got %v", results)
+	}
+}
+
+func TestReloadKeepsWrites(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stores.ndjson")
+	write := func(data string) {
+		if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}` + "\n" +
+		`{"store_id": 6, "name": "Exchange Square", "latitude": 22.2839, "longitude": 114.1581}` + "\n")
+	st, err := storage.NewFileStorage(t.TempDir(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	jg := NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, st, zap.NewNop())
+	if err := jg.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	name := "Plaza Hollywood, Diamond Hill"
+	if _, err := jg.PatchStore(context.Background(), 1, &StorePatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jg.DeleteStore(context.Background(), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	write(`{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}` + "\n" +
+		`{"store_id": 6, "name": "Exchange Square", "latitude": 22.2839, "longitude": 114.1581}` + "\n" +
+		`{"store_id": 8, "name": "Telford Plaza", "latitude": 22.3228, "longitude": 114.2134}` + "\n")
+	if err := jg.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	// a write racing the reload lands in storage or waits for the swap, either way it's kept
+	if _, err := jg.AddStore(context.Background(), &Store{Id: 13, Name: "Hong Kong Station", Latitude: 22.2844, Longitude: 114.1584}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for jg.GetStoreStats().Version != 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for reload")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if s, err := jg.GetStore(context.Background(), 1); err != nil || s.Name != name {
+		t.Errorf("expected patched store 1 to be kept, got %+v, %v", s, err)
+	}
+	if _, err := jg.GetStore(context.Background(), 6); !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("expected deleted store 6 to stay deleted, got %v", err)
+	}
+	for _, id := range []uint32{8, 13} {
+		if _, err := jg.GetStore(context.Background(), id); err != nil {
+			t.Errorf("expected store %d after reload, got %v", id, err)
+		}
+	}
+	if st.Len() != 3 {
+		t.Errorf("expected storage to hold reloaded stores with writes applied, got %d", st.Len())
+	}
+}
+
+// unreachableGeocoder fails every request and counts reachability checks
+type unreachableGeocoder struct {
+	checks int
+}
+
+func (*unreachableGeocoder) Geocode(ctx context.Context, postalCode string) (float64, float64, error) {
+	return 0, 0, errors.New("unreachable")
+}
+
+func (g *unreachableGeocoder) Check(ctx context.Context) error {
+	g.checks++
+	return errors.New("dial tcp: connection refused")
+}
+
+func TestCheckReadiness(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "stores.ndjson")
+	jg := NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+
+	r := jg.CheckReadiness(context.Background())
+	if r.Ready || r.Checks["data"] != CHECK_NOT_LOADED || r.Checks["geocoder"] != CHECK_NOT_CONFIGURED {
+		t.Errorf("expected not ready before loading, got %+v", r)
+	}
+
+	if err := jg.ProcessFile(context.Background()); err == nil {
+		t.Fatal("expected error loading missing data file")
+	}
+	if r = jg.CheckReadiness(context.Background()); r.Ready || r.Checks["data"] != CHECK_NOT_LOADED || r.Checks["load"] == CHECK_OK {
+		t.Errorf("expected failed initial load to be reported, got %+v", r)
+	}
+
+	// data files without a valid store don't count as loaded
+	if err := os.WriteFile(filePath, []byte(`{"store_id": 1, "name": "Plaza Hollywood"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := jg.ProcessFile(context.Background()); !errors.Is(err, ErrNoStores) {
+		t.Fatalf("expected no stores error, got %v", err)
+	}
+	if r = jg.CheckReadiness(context.Background()); r.Ready || r.Checks["data"] != CHECK_NOT_LOADED || r.Checks["load"] == CHECK_OK {
+		t.Errorf("expected load without stores to fail readiness, got %+v", r)
+	}
+
+	if err := os.WriteFile(filePath, []byte(`{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := jg.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r = jg.CheckReadiness(context.Background()); !r.Ready || r.Checks["load"] != CHECK_OK || jg.GetStoreStats().LoadError != "" {
+		t.Errorf("expected ready after loading, got %+v", r)
+	}
+
+	// a failed reload keeps serving the loaded data
+	os.Remove(filePath)
+	if err := jg.ProcessFile(context.Background()); err == nil {
+		t.Fatal("expected error loading missing data file")
+	}
+	if r = jg.CheckReadiness(context.Background()); !r.Ready || r.Checks["data"] != CHECK_OK || r.Checks["load"] == CHECK_OK {
+		t.Errorf("expected failed reload to be reported without failing readiness, got %+v", r)
+	}
+
+	gc := &unreachableGeocoder{}
+	jg.geocoder = gc
+	for i := 0; i < 3; i++ {
+		if r = jg.CheckReadiness(context.Background()); r.Ready || r.Checks["geocoder"] == CHECK_OK {
+			t.Errorf("expected unreachable geocoder to be reported, got %+v", r)
+		}
+	}
+	if gc.checks != 1 {
+		t.Errorf("expected geocoder check to be reused, got %d checks", gc.checks)
+	}
+}
+
+func TestLoadSkipsInvalidRecords(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stores.csv")
+	data := "Store ID,Name,City,Country,Latitude,Longitude\n" +
+		"1,Plaza Hollywood,Hong Kong,CN,22.3407,114.2016\n" +
+		"6,Exchange Square,Hong Kong,CN,22.2839,1x4.1581\n" +
+		"8,Telford Plaza,Kowloon,CN,,114.2134\n" +
+		"9,,Seattle,US,47.6097,-122.3422\n"
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jg := NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+	if err := jg.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats := jg.GetStoreStats(); stats.Count != 1 {
+		t.Errorf("expected only the valid store to be loaded, got %d", stats.Count)
+	}
+	if res, _ := jg.GetStoresForGeoPoint(context.Background(), 0, 0, 100, nil); len(res) != 0 {
+		t.Errorf("expected no stores indexed at 0,0, got %d", len(res))
+	}
+}
+
+func TestCancelledLoad(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stores.ndjson")
+	if err := os.WriteFile(filePath, []byte(`{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jg := NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := jg.ProcessFile(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled load error, got %v", err)
+	}
+	if stats := jg.GetStoreStats(); stats.Ready {
+		t.Errorf("expected cancelled load to not be applied, got %+v", stats)
+	}
+
+	if err := jg.ProcessFile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// loads after close are cancelled and keep current data
+	jg.Close()
+	if err := jg.ProcessFile(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected load after close to be cancelled, got %v", err)
+	}
+	if stats := jg.GetStoreStats(); stats.Count != 1 || stats.Version != 1 {
+		t.Errorf("expected current data to be kept, got %+v", stats)
+	}
+}
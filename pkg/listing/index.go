@@ -0,0 +1,224 @@
+package listing
+
+import (
+	"math"
+)
+
+// DEFAULT_CELL_SIZE is the default edge length, in degrees, of a spatial index cell
+const DEFAULT_CELL_SIZE = 0.1
+
+// MIN_EARTH_RADIUS_KM is a lower bound on the earth's radius of curvature,
+// used so that cell bounding boxes always cover the vincenty search circle
+const MIN_EARTH_RADIUS_KM = 6335.0
+
+type cellKey struct {
+	lat  int
+	long int
+}
+
+// cellIndex is a spatial index of store ids bucketed into a fixed lat/long grid.
+// A radius search only visits the cells covered by the bounding box of the search circle.
+type cellIndex struct {
+	size   float64
+	nLat   int
+	nLong  int
+	cells  map[cellKey][]uint32
+	points map[uint32]cellKey
+}
+
+func newCellIndex(size float64) *cellIndex {
+	if size <= 0 {
+		size = DEFAULT_CELL_SIZE
+	}
+	return &cellIndex{
+		size:   size,
+		nLat:   int(math.Ceil(180 / size)),
+		nLong:  int(math.Ceil(360 / size)),
+		cells:  map[cellKey][]uint32{},
+		points: map[uint32]cellKey{},
+	}
+}
+
+// add indexes store id at given point, moving it if it's already indexed
+func (ci *cellIndex) add(id uint32, lat, long float64) {
+	if _, ok := ci.points[id]; ok {
+		ci.remove(id)
+	}
+	k := ci.key(lat, long)
+	ci.cells[k] = append(ci.cells[k], id)
+	ci.points[id] = k
+}
+
+// remove drops store id from the index
+func (ci *cellIndex) remove(id uint32) {
+	k, ok := ci.points[id]
+	if !ok {
+		return
+	}
+	ids := ci.cells[k]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(ci.cells, k)
+	} else {
+		ci.cells[k] = ids
+	}
+	delete(ci.points, id)
+}
+
+// len returns the number of non empty cells
+func (ci *cellIndex) len() int {
+	return len(ci.cells)
+}
+
+// searchRadius returns the ids of stores in every cell intersecting
+// the bounding box of a circle of radius km around given point.
+// Results are candidates and must still be filtered by actual distance.
+func (ci *cellIndex) searchRadius(lat, long, km float64) []uint32 {
+	minLat, maxLat, minLong, maxLong := boundingBox(lat, long, km)
+	latLo, latHi := ci.latIndex(minLat), ci.latIndex(maxLat)
+
+	var longIdxs []int
+	if maxLong-minLong >= 360-ci.size {
+		longIdxs = make([]int, ci.nLong)
+		for i := range longIdxs {
+			longIdxs[i] = i
+		}
+	} else {
+		lo, hi := ci.longIndex(minLong), ci.longIndex(maxLong)
+		for i := lo; ; i = (i + 1) % ci.nLong {
+			longIdxs = append(longIdxs, i)
+			if i == hi {
+				break
+			}
+		}
+	}
+
+	ids := []uint32{}
+	// scan populated cells instead when the box covers more cells than are populated
+	if (latHi-latLo+1)*len(longIdxs) > len(ci.cells) {
+		inLong := make(map[int]bool, len(longIdxs))
+		for _, i := range longIdxs {
+			inLong[i] = true
+		}
+		for k, v := range ci.cells {
+			if k.lat >= latLo && k.lat <= latHi && inLong[k.long] {
+				ids = append(ids, v...)
+			}
+		}
+		return ids
+	}
+
+	for la := latLo; la <= latHi; la++ {
+		for _, lo := range longIdxs {
+			if v, ok := ci.cells[cellKey{lat: la, long: lo}]; ok {
+				ids = append(ids, v...)
+			}
+		}
+	}
+	return ids
+}
+
+func (ci *cellIndex) key(lat, long float64) cellKey {
+	return cellKey{lat: ci.latIndex(lat), long: ci.longIndex(long)}
+}
+
+func (ci *cellIndex) latIndex(lat float64) int {
+	i := int(math.Floor((lat + 90) / ci.size))
+	if i < 0 {
+		return 0
+	}
+	if i >= ci.nLat {
+		return ci.nLat - 1
+	}
+	return i
+}
+
+func (ci *cellIndex) longIndex(long float64) int {
+	long = math.Mod(long+180, 360)
+	if long < 0 {
+		long += 360
+	}
+	i := int(math.Floor(long / ci.size))
+	if i >= ci.nLong {
+		return ci.nLong - 1
+	}
+	return i
+}
+
+// boundingBox returns the lat/long bounds, in degrees, of a circle of radius km around given point.
+// Longitude bounds are unwrapped and may fall outside [-180, 180], a span of 360 covers all longitudes.
+func boundingBox(lat, long, km float64) (minLat, maxLat, minLong, maxLong float64) {
+	r := km / MIN_EARTH_RADIUS_KM
+	latR := lat * math.Pi / 180
+	minLatR, maxLatR := latR-r, latR+r
+
+	// circle covers a pole, all longitudes are in range
+	if maxLatR >= math.Pi/2 || minLatR <= -math.Pi/2 || r >= math.Pi/2 {
+		return math.Max(minLatR*180/math.Pi, -90), math.Min(maxLatR*180/math.Pi, 90), -180, 180
+	}
+
+	dLong := math.Asin(math.Sin(r)/math.Cos(latR)) * 180 / math.Pi
+	return minLatR * 180 / math.Pi, maxLatR * 180 / math.Pi, long - dLong, long + dLong
+}
+
+// attrIndex is a secondary index of store ids by a normalized attribute value, e.g. country.
+// Stores with an empty value aren't indexed.
+type attrIndex struct {
+	ids map[string]map[uint32]struct{}
+}
+
+func newAttrIndex() *attrIndex {
+	return &attrIndex{ids: map[string]map[uint32]struct{}{}}
+}
+
+// add indexes store id under given value
+func (ai *attrIndex) add(id uint32, value string) {
+	v := normalizeAttr(value)
+	if v == "" {
+		return
+	}
+	ids, ok := ai.ids[v]
+	if !ok {
+		ids = map[uint32]struct{}{}
+		ai.ids[v] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// remove drops store id from under given value
+func (ai *attrIndex) remove(id uint32, value string) {
+	v := normalizeAttr(value)
+	ids, ok := ai.ids[v]
+	if !ok {
+		return
+	}
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(ai.ids, v)
+	}
+}
+
+// lookup returns the ids indexed under any of given normalized values
+func (ai *attrIndex) lookup(values ...string) []uint32 {
+	ids := []uint32{}
+	for _, v := range values {
+		for id := range ai.ids[v] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// count returns the number of ids indexed under any of given normalized values
+func (ai *attrIndex) count(values ...string) int {
+	n := 0
+	for _, v := range values {
+		n += len(ai.ids[v])
+	}
+	return n
+}
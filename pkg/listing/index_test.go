@@ -0,0 +1,238 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+
+	"gitlab.com/xerra/common/vincenty"
+	"go.uber.org/zap"
+)
+
+func TestGetStoresForGeoPoint(t *testing.T) {
+	jg := NewJasonGateway(nil, nil, nil, zap.NewNop())
+	r := rand.New(rand.NewSource(1))
+	stores := []*Store{
+		{Id: 1, Latitude: 22.340700149536133, Longitude: 114.20169067382812},
+		{Id: 2, Latitude: 0.01, Longitude: 179.99},
+		{Id: 3, Latitude: 0.01, Longitude: -179.99},
+		{Id: 4, Latitude: 89.99, Longitude: 10},
+		{Id: 5, Latitude: 89.99, Longitude: -170},
+	}
+	for i := uint32(10); i < 2000; i++ {
+		stores = append(stores, &Store{Id: i, Latitude: r.Float64()*180 - 90, Longitude: r.Float64()*360 - 180})
+	}
+	for _, s := range stores {
+		jg.updateDataStores(s)
+	}
+
+	tests := []struct {
+		lat, long float64
+		dist      int
+	}{
+		{22.34, 114.2, 5},
+		{0, 180, 10},
+		{0, -179.995, 3},
+		{89.995, 100, 50},
+		{-45, 60, 1500},
+		{10, -100, 20000},
+	}
+	for i := 0; i < 50; i++ {
+		tests = append(tests, struct {
+			lat, long float64
+			dist      int
+		}{r.Float64()*180 - 90, r.Float64()*360 - 180, r.Intn(3000)})
+	}
+
+	for _, tt := range tests {
+		got, err := jg.GetStoresForGeoPoint(context.Background(), tt.lat, tt.long, tt.dist, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []uint32{}
+		origin := vincenty.LatLng{Latitude: tt.lat, Longitude: tt.long}
+		for _, s := range stores {
+			d := distanceBetween(origin, vincenty.LatLng{Latitude: s.Latitude, Longitude: s.Longitude})
+			if d.Kilometers() <= float64(tt.dist) {
+				want = append(want, s.Id)
+			}
+		}
+		gotIds := []uint32{}
+		for i, s := range got {
+			if i > 0 && s.DistanceKm < got[i-1].DistanceKm {
+				t.Fatalf("lat %f, long %f, dist %d: results not sorted by distance", tt.lat, tt.long, tt.dist)
+			}
+			gotIds = append(gotIds, s.Id)
+		}
+		sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+		sort.Slice(gotIds, func(i, j int) bool { return gotIds[i] < gotIds[j] })
+		if len(want) != len(gotIds) {
+			t.Fatalf("lat %f, long %f, dist %d: expected %d stores, got %d", tt.lat, tt.long, tt.dist, len(want), len(gotIds))
+		}
+		for i := range want {
+			if want[i] != gotIds[i] {
+				t.Fatalf("lat %f, long %f, dist %d: expected store %d, got %d", tt.lat, tt.long, tt.dist, want[i], gotIds[i])
+			}
+		}
+	}
+}
+
+func TestCellIndexRemove(t *testing.T) {
+	ci := newCellIndex(DEFAULT_CELL_SIZE)
+	ci.add(1, 10, 10)
+	ci.add(2, 10.01, 10.01)
+	ci.add(1, -10, -10)
+	if ci.len() != 2 {
+		t.Fatalf("expected 2 cells, got %d", ci.len())
+	}
+	ci.remove(1)
+	ci.remove(2)
+	if ci.len() != 0 {
+		t.Fatalf("expected empty index, got %d cells", ci.len())
+	}
+}
+
+func TestGetNearestStores(t *testing.T) {
+	jg := NewJasonGateway(nil, nil, nil, zap.NewNop())
+	r := rand.New(rand.NewSource(2))
+	stores := []*Store{}
+	for i := uint32(1); i < 500; i++ {
+		stores = append(stores, &Store{Id: i, Latitude: r.Float64()*180 - 90, Longitude: r.Float64()*360 - 180})
+	}
+	for _, s := range stores {
+		jg.updateDataStores(s)
+	}
+
+	for i := 0; i < 20; i++ {
+		lat, long, k := r.Float64()*180-90, r.Float64()*360-180, r.Intn(10)+1
+		got, err := jg.GetNearestStores(context.Background(), lat, long, k)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		origin := vincenty.LatLng{Latitude: lat, Longitude: long}
+		dists := []float64{}
+		for _, s := range stores {
+			dists = append(dists, distanceBetween(origin, vincenty.LatLng{Latitude: s.Latitude, Longitude: s.Longitude}).Kilometers())
+		}
+		sort.Float64s(dists)
+		if len(got) != k {
+			t.Fatalf("expected %d stores, got %d", k, len(got))
+		}
+		for j, res := range got {
+			if res.DistanceKm != dists[j] {
+				t.Fatalf("expected store %d at distance %f, got %f", j, dists[j], res.DistanceKm)
+			}
+		}
+	}
+
+	got, err := jg.GetNearestStores(context.Background(), 0, 0, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(stores) {
+		t.Fatalf("expected all %d stores, got %d", len(stores), len(got))
+	}
+
+	if _, err = jg.GetNearestStores(context.Background(), 0, 0, 0); err == nil {
+		t.Fatal("expected error for zero count")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = jg.GetNearestStores(ctx, 0, 0, 1000); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled nearest search to stop, got %v", err)
+	}
+	if _, err = jg.GetStoresForGeoPoint(ctx, 0, 0, MAX_SEARCH_DISTANCE_KM, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled radius search to stop, got %v", err)
+	}
+}
+
+func TestInitialBearing(t *testing.T) {
+	origin := vincenty.LatLng{Latitude: 0, Longitude: 0}
+	tests := []struct {
+		pos  vincenty.LatLng
+		want float64
+	}{
+		{vincenty.LatLng{Latitude: 1, Longitude: 0}, 0},
+		{vincenty.LatLng{Latitude: 0, Longitude: 1}, 90},
+		{vincenty.LatLng{Latitude: -1, Longitude: 0}, 180},
+		{vincenty.LatLng{Latitude: 0, Longitude: -1}, 270},
+	}
+	for _, tt := range tests {
+		if got := initialBearing(origin, tt.pos); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("bearing to %v: expected %f, got %f", tt.pos, tt.want, got)
+		}
+	}
+}
+
+func TestStoreFilters(t *testing.T) {
+	jg := NewJasonGateway(nil, nil, nil, zap.NewNop())
+	stores := []*Store{
+		{Id: 1, Name: "Plaza Hollywood", City: "Hong Kong", Country: "CN", Latitude: 22.3407, Longitude: 114.2016},
+		{Id: 6, Name: "Exchange Square", City: "Hong Kong", Country: "CN", Latitude: 22.2839, Longitude: 114.1581},
+		{Id: 8, Name: "Telford Plaza", City: "Kowloon", Country: "CN", Latitude: 22.3228, Longitude: 114.2134},
+		{Id: 9, Name: "Pike Place", City: "Seattle", Country: "US", Latitude: 47.6097, Longitude: -122.3422},
+		{Id: 10, Name: "Plaza Reserve", City: " seattle", Country: "us", Latitude: 47.6149, Longitude: -122.3281},
+	}
+	for _, s := range stores {
+		jg.updateDataStores(s)
+	}
+	ids := func(stores []*Store) []uint32 {
+		ids := []uint32{}
+		for _, s := range stores {
+			ids = append(ids, s.Id)
+		}
+		return ids
+	}
+
+	results, err := jg.GetStoresForGeoPoint(context.Background(), 22.32, 114.2, 10, &StoreFilter{Name: "PLAZA"})
+	if err != nil || len(results) != 2 || results[0].Id != 8 || results[1].Id != 1 {
+		t.Errorf("expected plazas 8, 1 nearest first, got %v, %v", results, err)
+	}
+	results, err = jg.GetStoresForGeoPoint(context.Background(), 22.32, 114.2, 10, &StoreFilter{Countries: []string{"us"}})
+	if err != nil || len(results) != 0 {
+		t.Errorf("expected no us stores near hong kong, got %v, %v", results, err)
+	}
+
+	tests := []struct {
+		filter *StoreFilter
+		want   []uint32
+	}{
+		{&StoreFilter{City: "hong kong"}, []uint32{1, 6}},
+		{&StoreFilter{City: "Seattle"}, []uint32{9, 10}},
+		{&StoreFilter{Countries: []string{"US", "cn"}, Name: "plaza"}, []uint32{1, 8, 10}},
+		{&StoreFilter{Countries: []string{"CN", "CN"}, City: "Kowloon"}, []uint32{8}},
+		{&StoreFilter{Countries: []string{"FR"}}, []uint32{}},
+	}
+	for _, tt := range tests {
+		got, total, err := jg.FindStores(context.Background(), tt.filter, 10)
+		if err != nil || total != len(tt.want) || !slices.Equal(ids(got), tt.want) {
+			t.Errorf("FindStores(%+v) = %v, %d, %v, want %v", tt.filter, ids(got), total, err, tt.want)
+		}
+	}
+	if got, total, _ := jg.FindStores(context.Background(), &StoreFilter{Countries: []string{"CN"}}, 2); total != 3 || !slices.Equal(ids(got), []uint32{1, 6}) {
+		t.Errorf("expected first 2 of 3 stores, got %v of %d", ids(got), total)
+	}
+	if _, _, err := jg.FindStores(context.Background(), &StoreFilter{Name: "plaza"}, 10); !errors.Is(err, ErrFilterRequired) {
+		t.Errorf("expected filter required error, got %v", err)
+	}
+
+	// secondary indexes follow store writes
+	city := "Kowloon"
+	if _, err := jg.PatchStore(context.Background(), 6, &StorePatch{City: &city}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jg.DeleteStore(context.Background(), 8); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := jg.FindStores(context.Background(), &StoreFilter{City: "kowloon"}, 10); !slices.Equal(ids(got), []uint32{6}) {
+		t.Errorf("expected patched store in its new city, got %v", ids(got))
+	}
+	if got, _, _ := jg.FindStores(context.Background(), &StoreFilter{City: "hong kong"}, 10); !slices.Equal(ids(got), []uint32{1}) {
+		t.Errorf("expected patched store out of its old city, got %v", ids(got))
+	}
+}
@@ -2,10 +2,19 @@ package listing
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
-	"github.com/hankgalt/starbucks/pkg/errors"
+	apperrors "github.com/hankgalt/starbucks/pkg/errors"
+)
+
+var (
+	ErrStoreNotFound = errors.New("store doesn't exist")
+	ErrStoreExists   = errors.New("store already exists")
+	ErrInvalidStore  = errors.New("invalid store")
 )
 
 // Store defines the properties of a store to be listed
@@ -19,58 +28,97 @@ type Store struct {
 	Created   time.Time `json:"created"`
 }
 
-func mapResultToStore(r map[string]interface{}) (*Store, error) {
-	storeJson, err := json.Marshal(r)
-	if err != nil {
-		log.Println("\033[31m Error marshalling result to store json \033[0m")
-		return nil, errors.WrapError(err, "Error marshalling result to store json")
+// Validate checks store has an id, a name and a valid location
+func (s *Store) Validate() error {
+	if s.Id == 0 {
+		return fmt.Errorf("%w: missing store_id", ErrInvalidStore)
 	}
-
-	var s Store
-	err = json.Unmarshal(storeJson, &s)
-	if err != nil {
-		log.Println("\033[31m Error unmarshalling store json to store \033[0m", err)
-		return nil, errors.WrapError(err, "Error unmarshalling store json to store")
+	if strings.TrimSpace(s.Name) == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidStore)
 	}
-	return &s, nil
+	if s.Latitude < -90 || s.Latitude > 90 {
+		return fmt.Errorf("%w: latitude %f out of range", ErrInvalidStore, s.Latitude)
+	}
+	if s.Longitude < -180 || s.Longitude > 180 {
+		return fmt.Errorf("%w: longitude %f out of range", ErrInvalidStore, s.Longitude)
+	}
+	return nil
+}
+
+// StorePatch holds the store fields to change in a partial update, nil fields are left as is
+type StorePatch struct {
+	Name      *string  `json:"name"`
+	Longitude *float64 `json:"longitude"`
+	Latitude  *float64 `json:"latitude"`
+	City      *string  `json:"city"`
+	Country   *string  `json:"country"`
 }
 
-type GeocoderResults struct {
-	Results []Result `json:"results"`
-	Status  string   `json:"status"`
+// apply returns a copy of store with patch applied
+func (p *StorePatch) apply(s *Store) *Store {
+	ns := *s
+	if p.Name != nil {
+		ns.Name = *p.Name
+	}
+	if p.Longitude != nil {
+		ns.Longitude = *p.Longitude
+	}
+	if p.Latitude != nil {
+		ns.Latitude = *p.Latitude
+	}
+	if p.City != nil {
+		ns.City = *p.City
+	}
+	if p.Country != nil {
+		ns.Country = *p.Country
+	}
+	return &ns
 }
 
-type Result struct {
-	AddressComponents []Address `json:"address_components"`
-	FormattedAddress  string    `json:"formatted_address"`
-	Geometry          Geometry  `json:"geometry"`
-	PlaceId           string    `json:"place_id"`
-	Types             []string  `json:"types"`
+// GeoPoint is a latitude/longitude pair in degrees
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
-// Address store each address is identified by the 'types'
-type Address struct {
-	LongName  string   `json:"long_name"`
-	ShortName string   `json:"short_name"`
-	Types     []string `json:"types"`
+// StoreResult is a store matched by a search along with its distance
+// and initial bearing, in degrees from north, from the search origin
+type StoreResult struct {
+	*Store
+	DistanceKm    float64  `json:"distance_km"`
+	DistanceMiles float64  `json:"distance_miles"`
+	Bearing       *float64 `json:"bearing,omitempty"`
 }
 
-// Geometry store each value in the geometry
-type Geometry struct {
-	Bounds       Bounds `json:"bounds"`
-	Location     LatLng `json:"location"`
-	LocationType string `json:"location_type"`
-	Viewport     Bounds `json:"viewport"`
+// TextResult is a store matched by a text search along with its relevance score,
+// and its distance from the search origin when one is given
+type TextResult struct {
+	*Store
+	Score      float64  `json:"score"`
+	DistanceKm *float64 `json:"distance_km,omitempty"`
 }
 
-// Bounds Northeast and Southwest
-type Bounds struct {
-	Northeast LatLng `json:"northeast"`
-	Southwest LatLng `json:"southwest"`
+// validateRecord checks a store mapped from a data file record is valid and that the
+// record has a location, without one the store would be indexed at 0,0
+func validateRecord(r map[string]interface{}, s *Store) error {
+	if r["latitude"] == nil || r["longitude"] == nil {
+		return fmt.Errorf("%w: storeId %d has no location", ErrInvalidStore, s.Id)
+	}
+	return s.Validate()
 }
 
-// LatLng store the latitude and longitude
-type LatLng struct {
-	Lat float64 `json:"lat"`
-	Lng float64 `json:"lng"`
+func mapResultToStore(r map[string]interface{}) (*Store, error) {
+	storeJson, err := json.Marshal(r)
+	if err != nil {
+		log.Println("\033[31m Error marshalling result to store json \033[0m")
+		return nil, apperrors.WrapError(err, "Error marshalling result to store json")
+	}
+
+	var s Store
+	err = json.Unmarshal(storeJson, &s)
+	if err != nil {
+		log.Println("\033[31m Error unmarshalling store json to store \033[0m", err)
+		return nil, apperrors.WrapError(err, "Error unmarshalling store json to store")
+	}
+	return &s, nil
 }
@@ -0,0 +1,183 @@
+package listing
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrEmptyQuery is returned by text searches without any letters or digits to search for
+var ErrEmptyQuery = errors.New("search query has no terms")
+
+// fields a term occurs in
+const (
+	NAME_FIELD uint8 = 1 << iota
+	CITY_FIELD
+)
+
+// text match weights, a name match counts for more than a city match
+// and an exact match for more than a prefix or typo tolerant one
+const (
+	NAME_FIELD_WEIGHT = 1.0
+	CITY_FIELD_WEIGHT = 0.5
+	EXACT_MATCH       = 1.0
+	PREFIX_MATCH      = 0.75
+	TYPO_MATCH        = 0.5
+)
+
+// MIN_PREFIX_LENGTH is the shortest query token matched as a prefix of longer terms
+const MIN_PREFIX_LENGTH = 2
+
+// textIndex is an inverted index of the terms in store names and cities.
+// Each term maps to the ids of stores it occurs in and the fields it occurs in.
+type textIndex struct {
+	postings map[string]map[uint32]uint8
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{postings: map[string]map[uint32]uint8{}}
+}
+
+// add indexes the terms of store name and city under store id
+func (ti *textIndex) add(id uint32, name, city string) {
+	for field, terms := range storeTerms(name, city) {
+		for _, t := range terms {
+			docs, ok := ti.postings[t]
+			if !ok {
+				docs = map[uint32]uint8{}
+				ti.postings[t] = docs
+			}
+			docs[id] |= field
+		}
+	}
+}
+
+// remove drops store id from under the terms of given name and city
+func (ti *textIndex) remove(id uint32, name, city string) {
+	for _, terms := range storeTerms(name, city) {
+		for _, t := range terms {
+			docs, ok := ti.postings[t]
+			if !ok {
+				continue
+			}
+			delete(docs, id)
+			if len(docs) == 0 {
+				delete(ti.postings, t)
+			}
+		}
+	}
+}
+
+func storeTerms(name, city string) map[uint8][]string {
+	return map[uint8][]string{NAME_FIELD: tokenize(name), CITY_FIELD: tokenize(city)}
+}
+
+// termMatch is an indexed term matching a query token and the weight of the match
+type termMatch struct {
+	term   string
+	weight float64
+}
+
+// match returns the indexed terms matching query token exactly, as a prefix,
+// or within the edits its length allows for typos. check is called every
+// CTX_CHECK_INTERVAL terms and stops matching when it returns an error.
+func (ti *textIndex) match(token string, check func() error) ([]termMatch, error) {
+	matches := []termMatch{}
+	if _, ok := ti.postings[token]; ok {
+		matches = append(matches, termMatch{token, EXACT_MATCH})
+	}
+	maxEdits := allowedEdits(token)
+	if len(token) < MIN_PREFIX_LENGTH && maxEdits == 0 {
+		return matches, nil
+	}
+
+	i := 0
+	for term := range ti.postings {
+		if i++; i%CTX_CHECK_INTERVAL == 0 {
+			if err := check(); err != nil {
+				return nil, err
+			}
+		}
+		if term == token {
+			continue
+		}
+		if len(token) >= MIN_PREFIX_LENGTH && strings.HasPrefix(term, token) {
+			matches = append(matches, termMatch{term, PREFIX_MATCH})
+			continue
+		}
+		if d, ok := editDistance(token, term, maxEdits); ok {
+			matches = append(matches, termMatch{term, TYPO_MATCH / float64(d)})
+		}
+	}
+	return matches, nil
+}
+
+// allowedEdits returns the number of typos tolerated in a query token, none for short tokens
+func allowedEdits(token string) int {
+	switch n := len([]rune(token)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// tokenize splits text into lower cased terms on anything but letters and digits,
+// with diacritics removed so "São Paulo" and "sao paulo" match
+func tokenize(text string) []string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// counting insertions, deletions, substitutions and transpositions of adjacent
+// characters. Returns false once the distance is known to exceed maxEdits.
+func editDistance(a, b string, maxEdits int) (int, bool) {
+	if maxEdits <= 0 {
+		return 0, false
+	}
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > maxEdits || -d > maxEdits {
+		return 0, false
+	}
+
+	// rows of the distance matrix for the previous two and the current prefix of a
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > maxEdits {
+			return 0, false
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	d := prev[len(rb)]
+	return d, d <= maxEdits
+}
@@ -0,0 +1,132 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Plaza Hollywood", []string{"plaza", "hollywood"}},
+		{"  Macy's 34th St. / Herald Sq ", []string{"macy", "s", "34th", "st", "herald", "sq"}},
+		{"São Paulo", []string{"sao", "paulo"}},
+		{"Zürich-Flughafen", []string{"zurich", "flughafen"}},
+		{" -- ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		maxEdits int
+		want     int
+		ok       bool
+	}{
+		{"plaza", "plaza", 1, 0, true},
+		{"plaza", "palza", 1, 1, true},
+		{"hollywod", "hollywood", 1, 1, true},
+		{"exchnage", "exchange", 2, 1, true},
+		{"telfrd", "telford", 1, 1, true},
+		{"square", "sqaure", 1, 1, true},
+		{"kowloon", "london", 2, 0, false},
+		{"plaza", "place", 1, 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := editDistance(tt.a, tt.b, tt.maxEdits); ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("editDistance(%q, %q, %d) = %d, %v, want %d, %v", tt.a, tt.b, tt.maxEdits, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSearchStores(t *testing.T) {
+	jg := NewJasonGateway(nil, nil, nil, zap.NewNop())
+	stores := []*Store{
+		{Id: 1, Name: "Plaza Hollywood", City: "Hong Kong", Country: "CN", Latitude: 22.3407, Longitude: 114.2016},
+		{Id: 6, Name: "Exchange Square", City: "Hong Kong", Country: "CN", Latitude: 22.2839, Longitude: 114.1581},
+		{Id: 8, Name: "Telford Plaza", City: "Kowloon", Country: "CN", Latitude: 22.3228, Longitude: 114.2134},
+		{Id: 9, Name: "Hollywood & Highland", City: "Los Angeles", Country: "US", Latitude: 34.1022, Longitude: -118.3406},
+		{Id: 10, Name: "Hollywood & Vine", City: "Los Angeles", Country: "US", Latitude: 34.1016, Longitude: -118.3267},
+		{Id: 11, Name: "Harbour City", City: "Kowloon", Country: "CN", Latitude: 22.2988, Longitude: 114.1686},
+	}
+	for _, s := range stores {
+		jg.updateDataStores(s)
+	}
+	search := func(query string, origin *GeoPoint) []uint32 {
+		t.Helper()
+		results, err := jg.SearchStores(context.Background(), query, origin, 10)
+		if err != nil {
+			t.Fatalf("unexpected error searching %q: %v", query, err)
+		}
+		ids := []uint32{}
+		for _, r := range results {
+			ids = append(ids, r.Id)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		query string
+		first uint32
+	}{
+		{"hollywood plaza", 1},
+		{"exchange sq", 6},
+		{"EXCHNAGE square", 6},
+		{"telfrod", 8},
+		{"kowloon plaza", 8},
+		{"harbour", 11},
+	}
+	for _, tt := range tests {
+		if ids := search(tt.query, nil); len(ids) == 0 || ids[0] != tt.first {
+			t.Errorf("search %q = %v, want %d first", tt.query, ids, tt.first)
+		}
+	}
+
+	// name matches rank above city matches
+	if ids := search("kowloon", nil); len(ids) != 2 || !slices.Contains(ids, 8) || !slices.Contains(ids, 11) {
+		t.Errorf("expected kowloon stores, got %v", ids)
+	}
+	if ids := search("harbour city", nil); ids[0] != 11 {
+		t.Errorf("expected name match first, got %v", ids)
+	}
+
+	// nearby stores are boosted among equal text matches
+	if ids := search("hollywood", &GeoPoint{Latitude: 34.1016, Longitude: -118.3267}); ids[0] != 10 || ids[1] != 9 || ids[2] != 1 {
+		t.Errorf("expected nearest hollywood stores first, got %v", ids)
+	}
+	if ids := search("hollywood", &GeoPoint{Latitude: 22.34, Longitude: 114.2}); ids[0] != 1 {
+		t.Errorf("expected hong kong hollywood store first, got %v", ids)
+	}
+
+	// the text index follows store writes
+	name := "Central Plaza"
+	if _, err := jg.PatchStore(context.Background(), 6, &StorePatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("exchange", nil); len(ids) != 0 {
+		t.Errorf("expected renamed store to not match its old name, got %v", ids)
+	}
+	if ids := search("central", nil); len(ids) != 1 || ids[0] != 6 {
+		t.Errorf("expected renamed store to match its new name, got %v", ids)
+	}
+
+	if _, err := jg.SearchStores(context.Background(), " & ", nil, 10); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("expected empty query error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := jg.SearchStores(ctx, "plaza", nil, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled search, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	apperrors "github.com/hankgalt/starbucks/pkg/errors"
+	"github.com/hankgalt/starbucks/pkg/logging"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// DEFAULT_CSV_MAPPING maps the upstream socrata export headers to store fields
+var DEFAULT_CSV_MAPPING = map[string]string{
+	"Id":        "store_id",
+	"Store ID":  "store_id",
+	"Name":      "name",
+	"City":      "city",
+	"Country":   "country",
+	"Latitude":  "latitude",
+	"Longitude": "longitude",
+}
+
+// DEFAULT_NUMERIC_FIELDS are record fields parsed as numbers from text formats
+var DEFAULT_NUMERIC_FIELDS = []string{"store_id", "latitude", "longitude"}
+
+// ReadCSV reads csv records from existing file, one by one, and returns individual
+// result through returned channel. The first row is the header, each column is
+// emitted under its mapped field name, or its normalized header when unmapped.
+// Records with a numeric field that doesn't parse are skipped.
+func ReadCSV(ctx context.Context, cancel func(), filePath string, mapping map[string]string) (<-chan map[string]interface{}, error) {
+	// check if file exists
+	err := ifFileExists(filePath)
+	if err != nil {
+		logging.Logger.Error("error checking file existence", zap.Error(err), zap.String("filePath", filePath))
+		cancel()
+		return nil, apperrors.WrapError(err, "error checking %s existence", filePath)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		logging.Logger.Error("error opening file", zap.Error(err), zap.String("filePath", filePath))
+		cancel()
+		return nil, apperrors.WrapError(err, "error reading file: %s", filePath)
+	}
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		logging.Logger.Error("error reading csv header", zap.Error(err), zap.String("filePath", filePath))
+		f.Close()
+		cancel()
+		return nil, apperrors.WrapError(err, "error reading csv header: %s", filePath)
+	}
+	fields := csvFields(header, mapping)
+	numeric := map[string]bool{}
+	for _, n := range DEFAULT_NUMERIC_FIELDS {
+		numeric[n] = true
+	}
+
+	resultStream := make(chan map[string]interface{}, 2)
+	go func() {
+		defer func() {
+			logging.Logger.Info("Closing result stream")
+			close(resultStream)
+		}()
+
+		defer func() {
+			logging.Logger.Info("Closing file")
+			if err := f.Close(); err != nil {
+				logging.Logger.Error("error closing file", zap.Error(err), zap.String("filePath", filePath))
+				cancel()
+			}
+		}()
+
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				var pe *csv.ParseError
+				if errors.As(err, &pe) {
+					logging.Logger.Error("skipping malformed csv record", zap.Error(err), zap.Int("line", pe.Line), zap.String("filePath", filePath))
+					metrics.LoadRecordErrors.WithLabelValues(metrics.MALFORMED_RECORD).Inc()
+					continue
+				}
+				logging.Logger.Error("error reading csv record", zap.Error(err), zap.String("filePath", filePath))
+				cancel()
+				return
+			}
+
+			result := make(map[string]interface{}, len(fields))
+			valid := true
+			for i, v := range record {
+				v = strings.TrimSpace(v)
+				if v == "" {
+					continue
+				}
+				if numeric[fields[i]] {
+					n, err := strconv.ParseFloat(v, 64)
+					if err != nil {
+						line, _ := r.FieldPos(i)
+						logging.Logger.Error("skipping csv record with invalid numeric value", zap.Error(err), zap.String("field", fields[i]), zap.Int("line", line), zap.String("filePath", filePath))
+						metrics.LoadRecordErrors.WithLabelValues(metrics.INVALID_FIELD).Inc()
+						valid = false
+						break
+					}
+					result[fields[i]] = n
+					continue
+				}
+				result[fields[i]] = v
+			}
+			if !valid {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case resultStream <- result:
+			}
+		}
+	}()
+
+	return resultStream, nil
+}
+
+// csvFields returns the record field name for each header column
+func csvFields(header []string, mapping map[string]string) []string {
+	if mapping == nil {
+		mapping = DEFAULT_CSV_MAPPING
+	}
+	lower := make(map[string]string, len(mapping))
+	for k, v := range mapping {
+		lower[strings.ToLower(strings.TrimSpace(k))] = v
+	}
+
+	fields := make([]string, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if f, ok := lower[strings.ToLower(h)]; ok {
+			fields[i] = f
+			continue
+		}
+		fields[i] = strings.ReplaceAll(strings.ToLower(h), " ", "_")
+	}
+	return fields
+}
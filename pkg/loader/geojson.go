@@ -0,0 +1,160 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hankgalt/starbucks/pkg/errors"
+	"github.com/hankgalt/starbucks/pkg/logging"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+type geoJSONFeature struct {
+	Type     string      `json:"type"`
+	Id       interface{} `json:"id"`
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// ReadGeoJSON reads Point features of a GeoJSON FeatureCollection from existing file,
+// one by one, and returns individual result through returned channel. Feature properties
+// are emitted under their mapped field name, or as is when unmapped, along with the point's
+// latitude & longitude. A feature's id is used as store_id when properties have none.
+func ReadGeoJSON(ctx context.Context, cancel func(), filePath string, mapping map[string]string) (<-chan map[string]interface{}, error) {
+	// check if file exists
+	err := ifFileExists(filePath)
+	if err != nil {
+		logging.Logger.Error("error checking file existence", zap.Error(err), zap.String("filePath", filePath))
+		cancel()
+		return nil, errors.WrapError(err, "error checking %s existence", filePath)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		logging.Logger.Error("error opening file", zap.Error(err), zap.String("filePath", filePath))
+		cancel()
+		return nil, errors.WrapError(err, "error reading file: %s", filePath)
+	}
+
+	numeric := map[string]bool{}
+	for _, n := range DEFAULT_NUMERIC_FIELDS {
+		numeric[n] = true
+	}
+
+	resultStream := make(chan map[string]interface{}, 2)
+	go func() {
+		defer func() {
+			logging.Logger.Info("Closing result stream")
+			close(resultStream)
+		}()
+
+		defer func() {
+			logging.Logger.Info("Closing file")
+			if err := f.Close(); err != nil {
+				logging.Logger.Error("error closing file", zap.Error(err), zap.String("filePath", filePath))
+				cancel()
+			}
+		}()
+
+		dec := json.NewDecoder(bufio.NewReader(f))
+		if err := seekFeatures(dec); err != nil {
+			logging.Logger.Error("error reading feature collection", zap.Error(err), zap.String("filePath", filePath))
+			cancel()
+			return
+		}
+
+		for idx := 0; dec.More(); idx++ {
+			var feature geoJSONFeature
+			if err := dec.Decode(&feature); err != nil {
+				logging.Logger.Error("error decoding feature json", zap.Error(err), zap.Int("feature", idx), zap.String("filePath", filePath))
+				cancel()
+				return
+			}
+			var coords []float64
+			if feature.Geometry == nil || feature.Geometry.Type != "Point" ||
+				json.Unmarshal(feature.Geometry.Coordinates, &coords) != nil || len(coords) < 2 {
+				logging.Logger.Error("skipping feature without point geometry", zap.Int("feature", idx), zap.String("filePath", filePath))
+				metrics.LoadRecordErrors.WithLabelValues(metrics.NO_POINT_GEOMETRY).Inc()
+				continue
+			}
+
+			result := make(map[string]interface{}, len(feature.Properties)+2)
+			for k, v := range feature.Properties {
+				if mk, ok := mapping[k]; ok {
+					k = mk
+				}
+				if s, ok := v.(string); ok && numeric[k] {
+					n, err := strconv.ParseFloat(s, 64)
+					if err != nil {
+						logging.Logger.Error("invalid numeric property", zap.Error(err), zap.String("field", k), zap.Int("feature", idx), zap.String("filePath", filePath))
+						metrics.LoadRecordErrors.WithLabelValues(metrics.INVALID_FIELD).Inc()
+						continue
+					}
+					v = n
+				}
+				result[k] = v
+			}
+			if _, ok := result["store_id"]; !ok && feature.Id != nil {
+				if s, ok := feature.Id.(string); ok {
+					if n, err := strconv.ParseFloat(s, 64); err == nil {
+						result["store_id"] = n
+					}
+				} else {
+					result["store_id"] = feature.Id
+				}
+			}
+			result["longitude"] = coords[0]
+			result["latitude"] = coords[1]
+
+			select {
+			case <-ctx.Done():
+				return
+			case resultStream <- result:
+			}
+		}
+	}()
+
+	return resultStream, nil
+}
+
+// seekFeatures advances decoder into the features array of a FeatureCollection,
+// skipping any other members preceding it
+func seekFeatures(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("expected feature collection object, got %v", t)
+	}
+
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if key, _ := t.(string); key == "features" {
+			t, err = dec.Token()
+			if err != nil {
+				return err
+			}
+			if d, ok := t.(json.Delim); !ok || d != '[' {
+				return fmt.Errorf("expected features array, got %v", t)
+			}
+			return nil
+		}
+		var skip json.RawMessage
+		if err = dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("feature collection has no features")
+}
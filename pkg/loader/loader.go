@@ -4,19 +4,72 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/hankgalt/starbucks/pkg/errors"
 	"github.com/hankgalt/starbucks/pkg/logging"
 	"go.uber.org/zap"
 )
 
+// supported data file formats
+const (
+	JSON_FORMAT    = "json"
+	CSV_FORMAT     = "csv"
+	NDJSON_FORMAT  = "ndjson"
+	GEOJSON_FORMAT = "geojson"
+)
+
+// Options configures how data files are mapped to records
+type Options struct {
+	// CSVMapping maps csv header columns to record fields, DEFAULT_CSV_MAPPING when nil
+	CSVMapping map[string]string
+	// GeoJSONMapping maps geojson feature properties to record fields
+	GeoJSONMapping map[string]string
+}
+
+// FormatForFile returns the data format for given file path from its extension
+func FormatForFile(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv":
+		return CSV_FORMAT
+	case ".ndjson", ".jsonl":
+		return NDJSON_FORMAT
+	case ".geojson":
+		return GEOJSON_FORMAT
+	default:
+		return JSON_FORMAT
+	}
+}
+
+// ReadFile streams records from data file of given format,
+// format is inferred from file extension when empty
+func ReadFile(ctx context.Context, cancel func(), filePath, format string, opts Options) (<-chan map[string]interface{}, error) {
+	if format == "" {
+		format = FormatForFile(filePath)
+	}
+
+	switch strings.ToLower(format) {
+	case JSON_FORMAT:
+		return ReadFileArray(ctx, cancel, filePath)
+	case CSV_FORMAT:
+		return ReadCSV(ctx, cancel, filePath, opts.CSVMapping)
+	case NDJSON_FORMAT, "jsonl":
+		return ReadNDJSON(ctx, cancel, filePath)
+	case GEOJSON_FORMAT:
+		return ReadGeoJSON(ctx, cancel, filePath, opts.GeoJSONMapping)
+	default:
+		logging.Logger.Error("unsupported data format", zap.String("format", format), zap.String("filePath", filePath))
+		cancel()
+		return nil, fmt.Errorf("unsupported data format: %s", format)
+	}
+}
+
 // ReadFileArray reads an array of json data from existing file, one by one,
 // and returns individual result at defined rate through returned channel
-func ReadFileArray(ctx context.Context, cancel func(), fileName string) (<-chan map[string]interface{}, error) {
-	filePath := filepath.Join("sample-data", fileName)
-
+func ReadFileArray(ctx context.Context, cancel func(), filePath string) (<-chan map[string]interface{}, error) {
 	// check if file exists
 	err := ifFileExists(filePath)
 	if err != nil {
@@ -79,7 +132,7 @@ func ReadFileArray(ctx context.Context, cancel func(), fileName string) (<-chan
 			logging.Logger.Error("error reading closing token", zap.Error(err), zap.Any("token", t), zap.String("filePath", filePath))
 			can()
 		}
-	}(ctx, cancel, fileName, f, resultStream)
+	}(ctx, cancel, filePath, f, resultStream)
 
 	return resultStream, nil
 }
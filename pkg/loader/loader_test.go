@@ -0,0 +1,118 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func readAll(t *testing.T, filePath, format string, opts Options) []map[string]interface{} {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rs, err := ReadFile(ctx, cancel, filePath, format, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := []map[string]interface{}{}
+	for r := range rs {
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestReadCSV(t *testing.T) {
+	filePath := writeFile(t, "stores.csv", "\ufeffStore ID,Name,City,Country,Latitude,Longitude,Postal Code\n"+
+		"1,Plaza Hollywood,Hong Kong,CN,22.3407,114.2016,\n"+
+		"6,\"Exchange Square, Central\",Hong Kong,CN,22.2839,114.1581,999077\n"+
+		"7,Bad Row,Hong Kong\n"+
+		"8,Telford Plaza,Kowloon,CN,22.3228,114.2134,\n"+
+		"9,Bad Latitude,Kowloon,CN,22.3x,114.2134,\n")
+
+	invalid := metrics.LoadRecordErrors.WithLabelValues(metrics.INVALID_FIELD)
+	before := testutil.ToFloat64(invalid)
+	results := readAll(t, filePath, "", Options{})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(results))
+	}
+	if n := testutil.ToFloat64(invalid) - before; n != 1 {
+		t.Errorf("expected record with malformed latitude to be counted, got %v", n)
+	}
+	if results[2]["store_id"] != float64(8) {
+		t.Errorf("expected record with malformed latitude to be skipped, got %v", results[2])
+	}
+	r := results[1]
+	if r["store_id"] != float64(6) || r["name"] != "Exchange Square, Central" || r["latitude"] != 22.2839 || r["postal_code"] != "999077" {
+		t.Errorf("unexpected record %v", r)
+	}
+	if _, ok := results[0]["postal_code"]; ok {
+		t.Errorf("expected empty value to be omitted, got %v", results[0])
+	}
+
+	results = readAll(t, filePath, CSV_FORMAT, Options{CSVMapping: map[string]string{"Name": "city", "City": "name"}})
+	if results[0]["name"] != "Hong Kong" || results[0]["city"] != "Plaza Hollywood" {
+		t.Errorf("expected custom mapping to be applied, got %v", results[0])
+	}
+}
+
+func TestReadNDJSON(t *testing.T) {
+	filePath := writeFile(t, "stores.jsonl", `{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}
+
+{"store_id": 6, "name": "Exchange Square"
+{"store_id": 8, "name": "Telford Plaza", "latitude": 22.3228, "longitude": 114.2134}
+[]
+{"store_id": 13, "name": "Hong Kong Station"}`)
+
+	malformed := metrics.LoadRecordErrors.WithLabelValues(metrics.MALFORMED_RECORD)
+	before := testutil.ToFloat64(malformed)
+	results := readAll(t, filePath, "", Options{})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(results))
+	}
+	if n := testutil.ToFloat64(malformed) - before; n != 2 {
+		t.Errorf("expected 2 malformed records counted, got %v", n)
+	}
+	for i, id := range []float64{1, 8, 13} {
+		if results[i]["store_id"] != id {
+			t.Errorf("expected store %v at %d, got %v", id, i, results[i])
+		}
+	}
+}
+
+func TestReadGeoJSON(t *testing.T) {
+	filePath := writeFile(t, "stores.geojson", `{
+	"type": "FeatureCollection",
+	"name": "starbucks",
+	"crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:OGC:1.3:CRS84"}},
+	"features": [
+		{"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [114.2016, 22.3407]}, "properties": {"title": "Plaza Hollywood", "city": "Hong Kong"}},
+		{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1]]}, "properties": {"title": "Not a store"}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [114.1581, 22.2839]}, "properties": {"store_id": "6", "title": "Exchange Square"}}
+	]
+}`)
+
+	results := readAll(t, filePath, "", Options{GeoJSONMapping: map[string]string{"title": "name"}})
+	if len(results) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(results))
+	}
+	r := results[0]
+	if r["store_id"] != float64(1) || r["name"] != "Plaza Hollywood" || r["latitude"] != 22.3407 || r["longitude"] != 114.2016 {
+		t.Errorf("unexpected record %v", r)
+	}
+	if results[1]["store_id"] != float64(6) || results[1]["name"] != "Exchange Square" {
+		t.Errorf("unexpected record %v", results[1])
+	}
+}
@@ -0,0 +1,98 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/hankgalt/starbucks/pkg/errors"
+	"github.com/hankgalt/starbucks/pkg/logging"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// ReadNDJSON reads newline delimited json objects from existing file, one by one,
+// and returns individual result through returned channel. Blank lines are skipped,
+// lines that fail to decode are logged with their line number and skipped.
+func ReadNDJSON(ctx context.Context, cancel func(), filePath string) (<-chan map[string]interface{}, error) {
+	// check if file exists
+	err := ifFileExists(filePath)
+	if err != nil {
+		logging.Logger.Error("error checking file existence", zap.Error(err), zap.String("filePath", filePath))
+		cancel()
+		return nil, errors.WrapError(err, "error checking %s existence", filePath)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		logging.Logger.Error("error opening file", zap.Error(err), zap.String("filePath", filePath))
+		cancel()
+		return nil, errors.WrapError(err, "error reading file: %s", filePath)
+	}
+
+	resultStream := make(chan map[string]interface{}, 2)
+	go func() {
+		defer func() {
+			logging.Logger.Info("Closing result stream")
+			close(resultStream)
+		}()
+
+		defer func() {
+			logging.Logger.Info("Closing file")
+			if err := f.Close(); err != nil {
+				logging.Logger.Error("error closing file", zap.Error(err), zap.String("filePath", filePath))
+				cancel()
+			}
+		}()
+
+		r := bufio.NewReader(f)
+		line := 0
+		for {
+			b, err := r.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				logging.Logger.Error("error reading ndjson line", zap.Error(err), zap.Int("line", line+1), zap.String("filePath", filePath))
+				cancel()
+				return
+			}
+			if len(b) == 0 && err == io.EOF {
+				return
+			}
+			line++
+
+			if b = bytes.TrimSpace(b); len(b) > 0 {
+				var result map[string]interface{}
+				if uerr := json.Unmarshal(b, &result); uerr != nil || result == nil {
+					logging.Logger.Error("skipping invalid ndjson record", zap.Error(uerr), zap.Int("line", line), zap.String("filePath", filePath))
+					metrics.LoadRecordErrors.WithLabelValues(metrics.MALFORMED_RECORD).Inc()
+				} else {
+					select {
+					case <-ctx.Done():
+						return
+					case resultStream <- result:
+					}
+				}
+			}
+
+			if err == io.EOF {
+				return
+			}
+		}
+	}()
+
+	return resultStream, nil
+}
+
+// WriteNDJSON writes each record as a single line of json
+func WriteNDJSON[T any](w io.Writer, records []T) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return errors.WrapError(err, "error encoding ndjson record")
+		}
+	}
+	return bw.Flush()
+}
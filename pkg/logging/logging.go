@@ -1,25 +1,73 @@
 package logging
 
 import (
+	"context"
+	"errors"
 	"os"
 
+	"github.com/hankgalt/starbucks/pkg/constants"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-var Logger *zap.Logger
+// Logger is a no-op logger until InitializeLogger is called
+var Logger = zap.NewNop()
 
-func InitializeLogger() {
+var logFiles []*os.File
+
+// InitializeLogger logs at given level and above to outputs, "stdout" and
+// "stderr" get console lines, other outputs are files appended with json lines
+func InitializeLogger(level string, outputs []string) error {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
 	config := zap.NewProductionEncoderConfig()
 	config.EncodeTime = zapcore.ISO8601TimeEncoder
 	fileEncoder := zapcore.NewJSONEncoder(config)
 	consoleEncoder := zapcore.NewConsoleEncoder(config)
-	logFile, _ := os.OpenFile("text.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	writer := zapcore.AddSync(logFile)
-	defaultLogLevel := zapcore.DebugLevel
-	core := zapcore.NewTee(
-		zapcore.NewCore(fileEncoder, writer, defaultLogLevel),
-		zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), defaultLogLevel),
-	)
-	Logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+
+	cores := []zapcore.Core{}
+	for _, o := range outputs {
+		switch o {
+		case "stdout":
+			cores = append(cores, zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), lvl))
+		case "stderr":
+			cores = append(cores, zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stderr), lvl))
+		default:
+			f, err := os.OpenFile(o, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			logFiles = append(logFiles, f)
+			cores = append(cores, zapcore.NewCore(fileEncoder, zapcore.AddSync(f), lvl))
+		}
+	}
+	Logger = zap.New(zapcore.NewTee(cores...), zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+	return nil
+}
+
+// Close flushes buffered log entries and closes the log files
+func Close() error {
+	// syncing stdout fails on some terminals, only log file errors are reported
+	_ = Logger.Sync()
+	var errs []error
+	for _, f := range logFiles {
+		errs = append(errs, f.Close())
+	}
+	logFiles = nil
+	return errors.Join(errs...)
+}
+
+// WithLogger returns a copy of context carrying given logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, constants.LoggerContextKey, logger)
+}
+
+// FromContext returns the logger carried by context, or fallback if it carries none
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(constants.LoggerContextKey).(*zap.Logger); ok && logger != nil {
+		return logger
+	}
+	return fallback
 }
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const NAMESPACE = "starbucks"
+
+// search kinds
+const (
+	GEOPOINT_SEARCH  = "geopoint"
+	NEAREST_SEARCH   = "nearest"
+	ATTRIBUTE_SEARCH = "attribute"
+	TEXT_SEARCH      = "text"
+)
+
+// store data load sources
+const (
+	FILES_SOURCE   = "files"
+	STORAGE_SOURCE = "storage"
+)
+
+// store data load outcomes
+const (
+	LOAD_SUCCESS = "success"
+	LOAD_FAILURE = "failure"
+)
+
+// reasons a store data record is skipped or loaded partially
+const (
+	MALFORMED_RECORD  = "malformed"
+	INVALID_FIELD     = "invalid_field"
+	INVALID_RECORD    = "invalid"
+	UNMAPPABLE_RECORD = "unmappable"
+	DUPLICATE_RECORD  = "duplicate"
+	NO_POINT_GEOMETRY = "no_point_geometry"
+)
+
+// geocoder outcomes other than google geocoding api statuses
+const (
+	GEOCODER_REQUEST_ERROR = "REQUEST_ERROR"
+	GEOCODER_DECODE_ERROR  = "DECODE_ERROR"
+)
+
+// Registry holds the process wide metrics, served along with per server collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "Number of http requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	StoresExamined = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "search_stores_examined",
+		Help:      "Number of candidate stores distance was calculated for per search.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"search"})
+
+	StoresReturned = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "search_stores_returned",
+		Help:      "Number of stores returned per search.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"search"})
+
+	GeocoderRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "geocoder_requests_total",
+		Help:      "Number of geocode requests by provider and status, google statuses are reported as returned.",
+	}, []string{"provider", "status"})
+
+	LoadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "load_duration_seconds",
+		Help:      "Duration of store data loads by source and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"source", "outcome"})
+
+	LoadRecordErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "load_record_errors_total",
+		Help:      "Number of store data records skipped or loaded partially, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		StoresExamined,
+		StoresReturned,
+		GeocoderRequests,
+		LoadDuration,
+		LoadRecordErrors,
+	)
+}
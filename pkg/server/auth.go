@@ -0,0 +1,27 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin rejects requests that don't carry the admin bearer token.
+// Requests are forbidden when no admin token is configured, so writes and
+// reloads stay closed unless a token is set.
+func (s *httpServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			writeError(w, http.StatusForbidden, errors.New("admin endpoints are disabled, no admin token is configured"))
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="starbucks"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
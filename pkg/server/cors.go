@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const CORS_ALLOWED_METHODS = "GET, POST, PUT, PATCH, DELETE"
+const CORS_ALLOWED_HEADERS = "Accept, Authorization, Content-Type, X-Request-ID"
+const CORS_EXPOSED_HEADERS = "Retry-After, X-Request-ID"
+
+// CORS_MAX_AGE_SECONDS is how long browsers may cache a preflight response
+const CORS_MAX_AGE_SECONDS = 600
+
+// cors lets browsers on allowed origins call the api. Preflight requests are
+// answered here, ahead of the router, since routes only match their own methods.
+func (s *httpServer) cors(next http.Handler) http.Handler {
+	if len(s.corsOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !slices.Contains(s.corsOrigins, "*") && !slices.Contains(s.corsOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", CORS_EXPOSED_HEADERS)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", CORS_ALLOWED_METHODS)
+			h.Set("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(CORS_MAX_AGE_SECONDS))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
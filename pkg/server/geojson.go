@@ -0,0 +1,88 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hankgalt/starbucks/pkg/listing"
+)
+
+const GEOJSON_CONTENT_TYPE = "application/geo+json"
+
+// FeatureCollection is a GeoJSON feature collection of store points
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+// Feature is a GeoJSON point feature of a store
+type Feature struct {
+	Type       string            `json:"type"`
+	Id         uint32            `json:"id"`
+	Geometry   PointGeometry     `json:"geometry"`
+	Properties FeatureProperties `json:"properties"`
+}
+
+// PointGeometry is a GeoJSON point, coordinates are longitude, latitude
+type PointGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type FeatureProperties struct {
+	StoreId       uint32   `json:"store_id"`
+	Name          string   `json:"name"`
+	City          string   `json:"city"`
+	Country       string   `json:"country"`
+	DistanceKm    float64  `json:"distance_km"`
+	DistanceMiles float64  `json:"distance_miles"`
+	Bearing       *float64 `json:"bearing,omitempty"`
+}
+
+func newFeatureCollection(stores []*listing.StoreResult) *FeatureCollection {
+	fc := &FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]*Feature, 0, len(stores)),
+	}
+	for _, st := range stores {
+		fc.Features = append(fc.Features, &Feature{
+			Type: "Feature",
+			Id:   st.Id,
+			Geometry: PointGeometry{
+				Type:        "Point",
+				Coordinates: [2]float64{st.Longitude, st.Latitude},
+			},
+			Properties: FeatureProperties{
+				StoreId:       st.Id,
+				Name:          st.Name,
+				City:          st.City,
+				Country:       st.Country,
+				DistanceKm:    st.DistanceKm,
+				DistanceMiles: st.DistanceMiles,
+				Bearing:       st.Bearing,
+			},
+		})
+	}
+	return fc
+}
+
+// acceptsGeoJSON checks if request's Accept header asks for GeoJSON, a quality of 0 marks it not acceptable
+func acceptsGeoJSON(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || mt != GEOJSON_CONTENT_TYPE {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if qv, err := strconv.ParseFloat(q, 64); err != nil || qv <= 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	api "github.com/hankgalt/starbucks/api/v1"
+	"github.com/hankgalt/starbucks/pkg/config"
+	"github.com/hankgalt/starbucks/pkg/geocoder"
+	"github.com/hankgalt/starbucks/pkg/listing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewGRPCServer creates a grpc server with the store service registered against given gateway,
+// search limits are taken from cfg
+func NewGRPCServer(gateway listing.Gateway, cfg *config.Configuration, logger *zap.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	gsrv := grpc.NewServer(opts...)
+	srv := newGRPCServer(gateway, cfg, logger)
+	api.RegisterStoreServiceServer(gsrv, srv)
+	return gsrv
+}
+
+type grpcServer struct {
+	api.UnimplementedStoreServiceServer
+	gateway           listing.Gateway
+	maxSearchDistance int
+	logger            *zap.Logger
+}
+
+func newGRPCServer(gateway listing.Gateway, cfg *config.Configuration, logger *zap.Logger) *grpcServer {
+	return &grpcServer{
+		gateway:           gateway,
+		maxSearchDistance: cfg.MaxSearchDistance,
+		logger:            logger,
+	}
+}
+
+func (s *grpcServer) GetStore(ctx context.Context, req *api.GetStoreRequest) (*api.GetStoreResponse, error) {
+	store, err := s.gateway.GetStore(ctx, req.Id)
+	if err != nil {
+		s.logger.Error("error getting store", zap.Error(err), zap.Uint32("storeId", req.Id))
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &api.GetStoreResponse{Store: mapStoreToProto(store)}, nil
+}
+
+func (s *grpcServer) SearchByGeoPoint(ctx context.Context, req *api.SearchByGeoPointRequest) (*api.SearchResponse, error) {
+	s.logger.Info("searchByGeoPoint request", zap.Any("request", req))
+	if err := s.checkDistance(req.Distance); err != nil {
+		return nil, err
+	}
+	if err := checkGeoPoint(req.Latitude, req.Longitude); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !s.gateway.GetStoreStats().Ready {
+		return nil, status.Error(codes.Unavailable, "store data is not loaded yet")
+	}
+	stores, err := s.gateway.GetStoresForGeoPoint(ctx, req.Latitude, req.Longitude, int(req.Distance), nil)
+	if err != nil {
+		s.logger.Error("error getting stores", zap.Error(err), zap.Float64("latitude", req.Latitude), zap.Float64("longitude", req.Longitude))
+		return nil, queryError(err)
+	}
+	return mapStoresToSearchResponse(&listing.GeoPoint{Latitude: req.Latitude, Longitude: req.Longitude}, stores), nil
+}
+
+func (s *grpcServer) SearchByPostalCode(ctx context.Context, req *api.SearchByPostalCodeRequest) (*api.SearchResponse, error) {
+	s.logger.Info("searchByPostalCode request", zap.Any("request", req))
+	if req.PostalCode == "" {
+		return nil, status.Error(codes.InvalidArgument, "postal code is required")
+	}
+	if err := s.checkDistance(req.Distance); err != nil {
+		return nil, err
+	}
+	if !s.gateway.GetStoreStats().Ready {
+		return nil, status.Error(codes.Unavailable, "store data is not loaded yet")
+	}
+	origin, stores, err := s.gateway.GetStoresForPostalCode(ctx, req.PostalCode, int(req.Distance), nil)
+	if err != nil {
+		s.logger.Error("error getting stores", zap.Error(err), zap.String("postalCode", req.PostalCode))
+		return nil, queryError(err)
+	}
+	return mapStoresToSearchResponse(origin, stores), nil
+}
+
+// checkDistance rejects a zero search distance and distances over the configured max,
+// a zero max leaves them unbounded
+func (s *grpcServer) checkDistance(dist uint32) error {
+	if dist == 0 {
+		return status.Error(codes.InvalidArgument, "distance is required")
+	}
+	if s.maxSearchDistance > 0 && int64(dist) > int64(s.maxSearchDistance) {
+		return status.Errorf(codes.InvalidArgument, "distance %d exceeds max %d km", dist, s.maxSearchDistance)
+	}
+	return nil
+}
+
+// queryError maps a query that ran out of time or was cancelled to the matching status,
+// a postal code that doesn't geocode to not found, an invalid one to invalid argument, postal code search without a geocoder
+// to failed precondition and other, geocoder, errors to unavailable
+func queryError(err error) error {
+	if st := status.FromContextError(err); st.Code() != codes.Unknown {
+		return st.Err()
+	}
+	switch {
+	case errors.Is(err, geocoder.ErrZeroResults):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, geocoder.ErrInvalidPostalCode), errors.Is(err, geocoder.ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, listing.ErrPostalSearchUnavailable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+}
+
+func mapStoresToSearchResponse(origin *listing.GeoPoint, stores []*listing.StoreResult) *api.SearchResponse {
+	res := &api.SearchResponse{
+		Origin: &api.GeoPoint{Latitude: origin.Latitude, Longitude: origin.Longitude},
+		Stores: make([]*api.StoreResult, 0, len(stores)),
+		Count:  uint32(len(stores)),
+	}
+	for _, st := range stores {
+		r := &api.StoreResult{
+			Store:         mapStoreToProto(st.Store),
+			DistanceKm:    st.DistanceKm,
+			DistanceMiles: st.DistanceMiles,
+		}
+		if st.Bearing != nil {
+			r.Bearing = *st.Bearing
+		}
+		res.Stores = append(res.Stores, r)
+	}
+	return res
+}
+
+func mapStoreToProto(s *listing.Store) *api.Store {
+	return &api.Store{
+		Id:        s.Id,
+		Name:      s.Name,
+		Longitude: float32(s.Longitude),
+		Latitude:  float32(s.Latitude),
+		City:      s.City,
+		Country:   s.Country,
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	api "github.com/hankgalt/starbucks/api/v1"
+	"github.com/hankgalt/starbucks/pkg/config"
+	"github.com/hankgalt/starbucks/pkg/geocoder"
+	"github.com/hankgalt/starbucks/pkg/listing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCSearchByPostalCode(t *testing.T) {
+	bearing := 45.0
+	gateway := &mockGateway{
+		getStoresForPostalCode: func(ctx context.Context, postalCode string, dist int) (*listing.GeoPoint, []*listing.StoreResult, error) {
+			if postalCode != "92612" || dist != 5 {
+				return nil, nil, errors.New("unexpected request")
+			}
+			return &listing.GeoPoint{Latitude: 33.66, Longitude: -117.82}, []*listing.StoreResult{
+				{Store: &listing.Store{Id: 7, Name: "Irvine Spectrum"}, DistanceKm: 1.5, DistanceMiles: 0.93, Bearing: &bearing},
+			}, nil
+		},
+	}
+	srv := newGRPCServer(gateway, &config.Configuration{}, zap.NewNop())
+
+	res, err := srv.SearchByPostalCode(context.Background(), &api.SearchByPostalCodeRequest{PostalCode: "92612", Distance: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Count != 1 || res.Stores[0].Store.Id != 7 || res.Stores[0].Bearing != bearing || res.Origin.Latitude != 33.66 {
+		t.Errorf("unexpected response %v", res)
+	}
+
+	if _, err = srv.SearchByPostalCode(context.Background(), &api.SearchByPostalCodeRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected invalid argument for missing postal code, got %v", err)
+	}
+}
+
+func TestGRPCGetStore(t *testing.T) {
+	gateway := &mockGateway{
+		getStore: func(ctx context.Context, storeId uint32) (*listing.Store, error) {
+			if storeId != 1 {
+				return nil, fmt.Errorf("%w: storeId %d", listing.ErrStoreNotFound, storeId)
+			}
+			return &listing.Store{Id: 1, Name: "Plaza Hollywood", City: "Hong Kong"}, nil
+		},
+	}
+	srv := newGRPCServer(gateway, &config.Configuration{}, zap.NewNop())
+
+	res, err := srv.GetStore(context.Background(), &api.GetStoreRequest{Id: 1})
+	if err != nil || res.Store.Name != "Plaza Hollywood" {
+		t.Errorf("expected store 1, got %v, %v", res, err)
+	}
+	if _, err = srv.GetStore(context.Background(), &api.GetStoreRequest{Id: 2}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestGRPCSearchErrors(t *testing.T) {
+	gateway := &mockGateway{
+		getStoresForGeoPoint: func(ctx context.Context, lat, long float64, dist int) ([]*listing.StoreResult, error) {
+			return []*listing.StoreResult{}, nil
+		},
+		getStoresForPostalCode: func(ctx context.Context, postalCode string, dist int) (*listing.GeoPoint, []*listing.StoreResult, error) {
+			if err := geocoder.ValidatePostalCode(postalCode); err != nil {
+				return nil, nil, err
+			}
+			switch postalCode {
+			case "00000":
+				return nil, nil, fmt.Errorf("geocoding 00000: %w", geocoder.ErrZeroResults)
+			case "92612":
+				return nil, nil, listing.ErrPostalSearchUnavailable
+			default:
+				return nil, nil, geocoder.ErrOverQueryLimit
+			}
+		},
+	}
+	srv := newGRPCServer(gateway, &config.Configuration{MaxSearchDistance: 10}, zap.NewNop())
+
+	if _, err := srv.SearchByGeoPoint(context.Background(), &api.SearchByGeoPointRequest{Latitude: 22.34, Longitude: 114.2, Distance: 50}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected invalid argument for distance over limit, got %v", err)
+	}
+	if _, err := srv.SearchByPostalCode(context.Background(), &api.SearchByPostalCodeRequest{PostalCode: "98101", Distance: 50}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected invalid argument for distance over limit, got %v", err)
+	}
+	if _, err := srv.SearchByGeoPoint(context.Background(), &api.SearchByGeoPointRequest{Latitude: 22.34, Longitude: 114.2, Distance: 10}); err != nil {
+		t.Errorf("expected distance at limit to be searched, got %v", err)
+	}
+	for _, req := range []*api.SearchByGeoPointRequest{
+		{Latitude: 222, Longitude: 114.2, Distance: 5},
+		{Latitude: -90.5, Longitude: 114.2, Distance: 5},
+		{Latitude: 22.34, Longitude: 914, Distance: 5},
+		{Latitude: 22.34, Longitude: -180.5, Distance: 5},
+		{Latitude: 22.34, Longitude: 114.2},
+	} {
+		if _, err := srv.SearchByGeoPoint(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("search %v: expected invalid argument, got %v", req, err)
+		}
+	}
+	if _, err := srv.SearchByPostalCode(context.Background(), &api.SearchByPostalCodeRequest{PostalCode: "98101"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected invalid argument for postal code search without distance, got %v", err)
+	}
+
+	tests := []struct {
+		postalCode string
+		want       codes.Code
+	}{
+		{"00000", codes.NotFound},
+		{"92612", codes.FailedPrecondition},
+		{"98101", codes.Unavailable},
+		{"92612|country:FR", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		if _, err := srv.SearchByPostalCode(context.Background(), &api.SearchByPostalCodeRequest{PostalCode: tt.postalCode, Distance: 5}); status.Code(err) != tt.want {
+			t.Errorf("postal code %s: expected %s, got %v", tt.postalCode, tt.want, err)
+		}
+	}
+}
@@ -1,31 +1,66 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/hankgalt/starbucks/pkg/config"
 	"github.com/hankgalt/starbucks/pkg/constants"
+	"github.com/hankgalt/starbucks/pkg/geocoder"
 	"github.com/hankgalt/starbucks/pkg/listing"
 	"go.uber.org/zap"
 )
 
-func NewHTTPServer(addr string, gateway *listing.JsonGateway, logger *zap.Logger) *http.Server {
-	httpsrv := newHTTPServer(gateway, logger)
+// NewHTTPServer creates an http server for given gateway, each request's context
+// is cancelled after configured request timeout. Cors origins, admin token and
+// request limits are taken from cfg.
+func NewHTTPServer(addr string, gateway listing.Gateway, cfg *config.Configuration, logger *zap.Logger) *http.Server {
+	httpsrv := newHTTPServer(gateway, cfg, logger)
 	r := mux.NewRouter()
+	r.Use(recordRoute, httpsrv.withDeadline, httpsrv.limitBody)
 
-	r.HandleFunc(constants.SEARCH_URL, httpsrv.handleSearch).Methods("POST")
+	r.HandleFunc(constants.SEARCH_URL, httpsrv.requireReady(httpsrv.handleSearch)).Methods("POST")
+	r.HandleFunc(constants.NEAREST_URL, httpsrv.requireReady(httpsrv.handleNearest)).Methods("POST")
+	r.HandleFunc(constants.EXPORT_URL, httpsrv.handleExport).Methods("GET")
+	r.HandleFunc(constants.STORES_URL, httpsrv.handleGetStores).Methods("GET")
+	r.HandleFunc(constants.STORE_SEARCH_URL, httpsrv.requireReady(httpsrv.handleTextSearch)).Methods("GET")
+	r.HandleFunc(constants.STORE_URL, httpsrv.handleGetStore).Methods("GET")
+	r.HandleFunc(constants.STORE_URL, httpsrv.requireAdmin(httpsrv.handleCreateStore)).Methods("POST")
+	r.HandleFunc(constants.STORE_URL, httpsrv.requireAdmin(httpsrv.handleUpdateStore)).Methods("PUT")
+	r.HandleFunc(constants.STORE_URL, httpsrv.requireAdmin(httpsrv.handlePatchStore)).Methods("PATCH")
+	r.HandleFunc(constants.STORE_URL, httpsrv.requireAdmin(httpsrv.handleDeleteStore)).Methods("DELETE")
+	r.HandleFunc(constants.ADMIN_RELOAD_URL, httpsrv.requireAdmin(httpsrv.handleReload)).Methods("POST")
 	r.HandleFunc(constants.HEALTH_CHECK_URL, httpsrv.handleHealthCheck)
+	r.HandleFunc(constants.LIVENESS_URL, httpsrv.handleLiveness).Methods("GET")
+	r.HandleFunc(constants.READINESS_URL, httpsrv.handleReadiness).Methods("GET")
+	r.Handle(constants.METRICS_URL, metricsHandler(gateway)).Methods("GET")
 
+	// metrics, tracing and request logging wrap the router, mux only runs its middleware
+	// on matched routes and requests for unknown paths or methods are counted and logged too
 	return &http.Server{
 		Addr:    addr,
-		Handler: r,
+		Handler: trackRoute(httpsrv.instrument(httpsrv.traceRequests(httpsrv.logRequests(httpsrv.cors(r))))),
 	}
 }
 
 type httpServer struct {
-	gateway *listing.JsonGateway
-	logger  *zap.Logger
+	gateway           listing.Gateway
+	requestTimeout    time.Duration
+	corsOrigins       []string
+	adminToken        string
+	maxRequestBytes   int64
+	maxSearchDistance int
+	maxNearestCount   int
+	logger            *zap.Logger
 }
 
 type SearchRequest struct {
@@ -33,55 +68,614 @@ type SearchRequest struct {
 	Longitude  float64 `json:"longitude"`
 	PostalCode string  `json:"postalCode"`
 	Distance   int     `json:"distance"`
+	Bearing    bool    `json:"bearing"`
+	// Countries, City and Name optionally filter stores, see listing.StoreFilter
+	Countries []string `json:"countries"`
+	City      string   `json:"city"`
+	Name      string   `json:"name"`
+}
+
+// validate checks search distance is positive and within maxDistance, a zero max leaves it unbounded,
+// and that the search point is a valid location when no postal code is given
+func (r *SearchRequest) validate(maxDistance int) error {
+	if r.Distance <= 0 {
+		return fmt.Errorf("invalid distance: %d", r.Distance)
+	}
+	if maxDistance > 0 && r.Distance > maxDistance {
+		return fmt.Errorf("distance %d exceeds max %d km", r.Distance, maxDistance)
+	}
+	if r.PostalCode != "" {
+		return nil
+	}
+	return checkGeoPoint(r.Latitude, r.Longitude)
+}
+
+func (r *SearchRequest) filter() *listing.StoreFilter {
+	return &listing.StoreFilter{Countries: r.Countries, City: r.City, Name: r.Name}
 }
 
 type SearchResponse struct {
+	Origin *listing.GeoPoint      `json:"origin"`
+	Stores []*listing.StoreResult `json:"stores"`
+	Count  int                    `json:"count"`
+}
+
+type NearestRequest struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+	Bearing   bool    `json:"bearing"`
+}
+
+type StoresResponse struct {
+	Stores  []*listing.Store `json:"stores"`
+	Missing []uint32         `json:"missing"`
+	Count   int              `json:"count"`
+}
+
+// TextSearchResponse holds text search results, best match first
+type TextSearchResponse struct {
+	Stores []*listing.TextResult `json:"stores"`
+	Count  int                   `json:"count"`
+}
+
+// FindStoresResponse holds upto limit stores matching a store query, along with the total number of matches
+type FindStoresResponse struct {
 	Stores []*listing.Store `json:"stores"`
 	Count  int              `json:"count"`
+	Total  int              `json:"total"`
+}
+
+// ErrorResponse is the body of store resource error responses
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
 }
 
-func newHTTPServer(gateway *listing.JsonGateway, logger *zap.Logger) *httpServer {
+func newHTTPServer(gateway listing.Gateway, cfg *config.Configuration, logger *zap.Logger) *httpServer {
 	return &httpServer{
-		gateway: gateway,
-		logger:  logger,
+		gateway:           gateway,
+		requestTimeout:    cfg.RequestTimeoutDuration(),
+		corsOrigins:       cfg.CORSAllowedOrigins,
+		adminToken:        cfg.AdminToken,
+		maxRequestBytes:   cfg.MaxRequestBytes,
+		maxSearchDistance: cfg.MaxSearchDistance,
+		maxNearestCount:   cfg.MaxNearestCount,
+		logger:            logger,
 	}
 }
 
+// withDeadline bounds the request context by the request timeout
+func (s *httpServer) withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.requestTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// limitBody caps request bodies at the configured size, a zero size leaves them unbounded
+func (s *httpServer) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.maxRequestBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxRequestBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *httpServer) handleHealthCheck(rw http.ResponseWriter, req *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 	_, _ = rw.Write([]byte("Success"))
 }
 
+// requireReady responds with service unavailable until store data is loaded
+func (s *httpServer) requireReady(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.gateway.GetStoreStats().Ready {
+			w.Header().Set("Retry-After", strconv.Itoa(constants.RETRY_AFTER_SECONDS))
+			writeError(w, http.StatusServiceUnavailable, errors.New("store data is not loaded yet"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleLiveness reports the process is up and serving
+func (s *httpServer) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// handleReadiness reports whether the gateway is ready to serve, with the outcome of each check
+func (s *httpServer) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	readiness := s.gateway.CheckReadiness(r.Context())
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+		logger.Info("not ready", zap.Any("checks", readiness.Checks))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(readiness)
+}
+
 func (s *httpServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
 	var req SearchRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		s.logger.Error("error decoding searchRequest", zap.Error(err))
+		logger.Error("error decoding searchRequest", zap.Error(err))
+		http.Error(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+	logger.Debug("searchRequest", zap.Any("request", req))
+	if err = req.validate(s.maxSearchDistance); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	s.logger.Info("searchRequest", zap.Any("request", req))
-	var stores []*listing.Store
+	var origin *listing.GeoPoint
+	var stores []*listing.StoreResult
 	if req.PostalCode != "" {
-		stores, err = s.gateway.GetStoresForPostalCode(req.PostalCode, req.Distance)
+		origin, stores, err = s.gateway.GetStoresForPostalCode(r.Context(), req.PostalCode, req.Distance, req.filter())
 		if err != nil {
-			s.logger.Error("error getting stores", zap.Error(err), zap.String("postalCode", req.PostalCode))
-			http.Error(w, err.Error(), http.StatusNoContent)
+			logger.Error("error getting stores", zap.Error(err), zap.String("postalCode", req.PostalCode))
+			http.Error(w, err.Error(), queryErrorStatus(err, http.StatusBadGateway))
 			return
 		}
 	} else {
-		stores, err = s.gateway.GetStoresForGeoPoint(req.Latitude, req.Longitude, req.Distance)
+		origin = &listing.GeoPoint{Latitude: req.Latitude, Longitude: req.Longitude}
+		stores, err = s.gateway.GetStoresForGeoPoint(r.Context(), req.Latitude, req.Longitude, req.Distance, req.filter())
 		if err != nil {
-			s.logger.Error("error getting stores", zap.Error(err), zap.Float64("latitude", req.Latitude), zap.Float64("longitude", req.Longitude))
-			http.Error(w, err.Error(), http.StatusNoContent)
+			logger.Error("error getting stores", zap.Error(err), zap.Float64("latitude", req.Latitude), zap.Float64("longitude", req.Longitude))
+			http.Error(w, err.Error(), queryErrorStatus(err, http.StatusInternalServerError))
 			return
 		}
 	}
+	if !req.Bearing {
+		for _, st := range stores {
+			st.Bearing = nil
+		}
+	}
+
+	s.writeSearchResponse(w, r, &SearchResponse{Origin: origin, Stores: stores, Count: len(stores)})
+}
 
-	res := SearchResponse{Stores: stores, Count: len(stores)}
-	err = json.NewEncoder(w).Encode(res)
+func (s *httpServer) handleNearest(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	var req NearestRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
+		logger.Error("error decoding nearestRequest", zap.Error(err))
+		http.Error(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+	logger.Debug("nearestRequest", zap.Any("request", req))
+	if req.Count < 0 {
+		http.Error(w, fmt.Sprintf("invalid count: %d", req.Count), http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = constants.DEFAULT_NEAREST_COUNT
+	}
+	if s.maxNearestCount > 0 && req.Count > s.maxNearestCount {
+		http.Error(w, fmt.Sprintf("count %d exceeds max %d", req.Count, s.maxNearestCount), http.StatusBadRequest)
+		return
+	}
+	stores, err := s.gateway.GetNearestStores(r.Context(), req.Latitude, req.Longitude, req.Count)
+	if err != nil {
+		logger.Error("error getting nearest stores", zap.Error(err), zap.Float64("latitude", req.Latitude), zap.Float64("longitude", req.Longitude))
+		http.Error(w, err.Error(), queryErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	if !req.Bearing {
+		for _, st := range stores {
+			st.Bearing = nil
+		}
+	}
+
+	s.writeSearchResponse(w, r, &SearchResponse{
+		Origin: &listing.GeoPoint{Latitude: req.Latitude, Longitude: req.Longitude},
+		Stores: stores,
+		Count:  len(stores),
+	})
+}
+
+// writeSearchResponse writes search results as json, or as a GeoJSON feature collection when the client accepts it
+func (s *httpServer) writeSearchResponse(w http.ResponseWriter, r *http.Request, res *SearchResponse) {
+	var body interface{} = res
+	if acceptsGeoJSON(r) {
+		w.Header().Set("Content-Type", GEOJSON_CONTENT_TYPE)
+		body = newFeatureCollection(res.Stores)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *httpServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	count, err := s.gateway.ExportNDJSON(r.Context(), w)
+	if err != nil {
+		logger.Error("error exporting stores", zap.Error(err))
+		return
+	}
+	logger.Info("exported stores", zap.Int("count", count))
+}
+
+func (s *httpServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	err := s.gateway.Reload()
+	if errors.Is(err, listing.ErrLoadInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error("error reloading stores", zap.Error(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(s.gateway.GetStoreStats())
+}
+
+func (s *httpServer) handleGetStore(w http.ResponseWriter, r *http.Request) {
+	id, err := storeId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	store, err := s.gateway.GetStore(r.Context(), id)
+	if err != nil {
+		writeError(w, storeErrorStatus(err), err)
+		return
+	}
+	writeStore(w, http.StatusOK, store)
+}
+
+// handleGetStores gets stores by ids, or finds them by attributes when no ids are given
+func (s *httpServer) handleGetStores(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("ids") {
+		s.handleFindStores(w, r)
+		return
+	}
+	ids, err := storeIds(r.URL.Query().Get("ids"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stores, missing := s.gateway.GetStores(r.Context(), ids)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(StoresResponse{Stores: stores, Missing: missing, Count: len(stores)})
+}
+
+// handleFindStores finds stores by country, city and name, e.g. /stores?country=US,CA&city=seattle&name=reserve
+func (s *httpServer) handleFindStores(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	q := r.URL.Query()
+	limit, err := parseLimit(q.Get("limit"), constants.DEFAULT_FIND_LIMIT)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter := &listing.StoreFilter{City: q.Get("city"), Name: q.Get("name")}
+	if v := q.Get("country"); v != "" {
+		filter.Countries = strings.Split(v, ",")
+	}
+
+	stores, total, err := s.gateway.FindStores(r.Context(), filter, limit)
+	if errors.Is(err, listing.ErrFilterRequired) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing store ids, %w", err))
+		return
+	}
+	if err != nil {
+		logger.Error("error finding stores", zap.Error(err), zap.Any("filter", filter))
+		writeError(w, queryErrorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(FindStoresResponse{Stores: stores, Count: len(stores), Total: total})
+}
+
+// handleTextSearch searches store names and cities for query text, e.g. /stores/search?q=exchange+sq,
+// optionally boosting stores near latitude and longitude
+func (s *httpServer) handleTextSearch(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	q := r.URL.Query()
+	limit, err := parseLimit(q.Get("limit"), constants.DEFAULT_TEXT_SEARCH_LIMIT)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	origin, err := geoPoint(q.Get("latitude"), q.Get("longitude"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stores, err := s.gateway.SearchStores(r.Context(), q.Get("q"), origin, limit)
+	if errors.Is(err, listing.ErrEmptyQuery) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		logger.Error("error searching stores", zap.Error(err), zap.String("query", q.Get("q")))
+		writeError(w, queryErrorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(TextSearchResponse{Stores: stores, Count: len(stores)})
+}
+
+func (s *httpServer) handleCreateStore(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	store, err := decodeStore(r)
+	if err != nil {
+		logger.Error("error decoding store", zap.Error(err))
+		writeError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	store, err = s.gateway.AddStore(r.Context(), store)
+	if err != nil {
+		logger.Error("error adding store", zap.Error(err))
+		writeError(w, storeErrorStatus(err), err)
+		return
+	}
+	writeStore(w, http.StatusCreated, store)
+}
+
+func (s *httpServer) handleUpdateStore(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	store, err := decodeStore(r)
+	if err != nil {
+		logger.Error("error decoding store", zap.Error(err))
+		writeError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	store, err = s.gateway.UpdateStore(r.Context(), store)
+	if err != nil {
+		logger.Error("error updating store", zap.Error(err))
+		writeError(w, storeErrorStatus(err), err)
+		return
+	}
+	writeStore(w, http.StatusOK, store)
+}
+
+func (s *httpServer) handlePatchStore(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	id, err := storeId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	patch, err := decodePatch(r)
+	if err != nil {
+		logger.Error("error decoding store patch", zap.Error(err))
+		writeError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	store, err := s.gateway.PatchStore(r.Context(), id, patch)
+	if err != nil {
+		logger.Error("error patching store", zap.Error(err))
+		writeError(w, storeErrorStatus(err), err)
+		return
+	}
+	writeStore(w, http.StatusOK, store)
+}
+
+func (s *httpServer) handleDeleteStore(w http.ResponseWriter, r *http.Request) {
+	logger := s.requestLogger(r)
+	id, err := storeId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = s.gateway.DeleteStore(r.Context(), id); err != nil {
+		logger.Error("error deleting store", zap.Error(err))
+		writeError(w, storeErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storeId returns the store id from request path
+func storeId(r *http.Request) (uint32, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid store id: %s", mux.Vars(r)["id"])
+	}
+	return uint32(id), nil
+}
+
+// storeBody is a store request body, its location is decoded apart to tell a missing coordinate from a zero one
+type storeBody struct {
+	listing.Store
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// decodeStore decodes store from request body, taking its id from request path.
+// Like loaded records, a store without latitude or longitude is invalid instead of being placed at 0,0.
+func decodeStore(r *http.Request) (*listing.Store, error) {
+	id, err := storeId(r)
+	if err != nil {
+		return nil, err
+	}
+	var body storeBody
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Latitude == nil || body.Longitude == nil {
+		return nil, fmt.Errorf("%w: missing latitude or longitude", listing.ErrInvalidStore)
+	}
+	store := body.Store
+	if store.Id != 0 && store.Id != id {
+		return nil, fmt.Errorf("store_id %d doesn't match path id %d", store.Id, id)
+	}
+	store.Id = id
+	store.Latitude, store.Longitude = *body.Latitude, *body.Longitude
+	return &store, nil
+}
+
+// decodePatch decodes a store patch from request body, a patch can change the location but not remove it
+func decodePatch(r *http.Request) (*listing.StorePatch, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var patch listing.StorePatch
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&patch); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"latitude", "longitude"} {
+		if v, ok := fields[k]; ok && string(v) == "null" {
+			return nil, fmt.Errorf("%w: %s can't be removed", listing.ErrInvalidStore, k)
+		}
+	}
+	return &patch, nil
+}
+
+// storeIds parses a comma separated list of store ids
+func storeIds(v string) ([]uint32, error) {
+	ids := []uint32{}
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid store id: %s", p)
+		}
+		ids = append(ids, uint32(id))
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("missing store ids")
+	}
+	if len(ids) > constants.MAX_BATCH_STORE_IDS {
+		return nil, fmt.Errorf("too many store ids, max %d", constants.MAX_BATCH_STORE_IDS)
+	}
+	return ids, nil
+}
+
+// parseLimit parses the number of stores a store query or text search returns, defaults to def
+func parseLimit(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit: %s", v)
+	}
+	if limit > constants.MAX_FIND_LIMIT {
+		return 0, fmt.Errorf("limit %d exceeds max %d", limit, constants.MAX_FIND_LIMIT)
+	}
+	return limit, nil
+}
+
+// checkGeoPoint checks latitude and longitude are within range
+func checkGeoPoint(lat, long float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("invalid latitude: %g", lat)
+	}
+	if long < -180 || long > 180 {
+		return fmt.Errorf("invalid longitude: %g", long)
+	}
+	return nil
+}
+
+// geoPoint parses an optional point, latitude and longitude are given together or not at all
+func geoPoint(lat, long string) (*listing.GeoPoint, error) {
+	if lat == "" && long == "" {
+		return nil, nil
+	}
+	la, err := strconv.ParseFloat(lat, 64)
+	if err != nil || la < -90 || la > 90 {
+		return nil, fmt.Errorf("invalid latitude: %s", lat)
+	}
+	lo, err := strconv.ParseFloat(long, 64)
+	if err != nil || lo < -180 || lo > 180 {
+		return nil, fmt.Errorf("invalid longitude: %s", long)
+	}
+	return &listing.GeoPoint{Latitude: la, Longitude: lo}, nil
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Status: status})
+}
+
+func writeStore(w http.ResponseWriter, status int, store *listing.Store) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(store)
+}
+
+// decodeErrorStatus maps a request body over the size limit to request entity too large, other errors to bad request
+func decodeErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// queryErrorStatus maps a query that ran out of time to gateway timeout, an invalid postal code
+// to bad request, one that doesn't geocode to not found, postal code search without a geocoder
+// to service unavailable, a failed geocoding request to bad gateway and other errors to fallback
+func queryErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, geocoder.ErrInvalidPostalCode), errors.Is(err, geocoder.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, geocoder.ErrZeroResults):
+		return http.StatusNotFound
+	case errors.Is(err, listing.ErrPostalSearchUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, geocoder.ErrOverQueryLimit), errors.Is(err, geocoder.ErrRequestDenied),
+		errors.Is(err, geocoder.ErrServerError), errors.Is(err, geocoder.ErrUnknown):
+		return http.StatusBadGateway
+	default:
+		return fallback
+	}
+}
+
+// storeErrorStatus maps gateway store errors to http status
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, listing.ErrStoreNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, listing.ErrStoreExists):
+		return http.StatusConflict
+	case errors.Is(err, listing.ErrInvalidStore):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
 }
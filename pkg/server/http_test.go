@@ -0,0 +1,676 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"github.com/hankgalt/starbucks/pkg/geocoder"
+	"github.com/hankgalt/starbucks/pkg/listing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TEST_ADMIN_TOKEN is the admin token of servers set up by setupServer, doRequest sends it
+const TEST_ADMIN_TOKEN = "s3cret"
+
+func setupServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return setupServerWithConfig(t, &config.Configuration{AdminToken: TEST_ADMIN_TOKEN})
+}
+
+func setupServerWithConfig(t *testing.T, cfg *config.Configuration) *httptest.Server {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "stores.ndjson")
+	data := `{"store_id": 1, "name": "Plaza Hollywood", "city": "Hong Kong", "country": "CN", "latitude": 22.340700149536133, "longitude": 114.20169067382812}
+{"store_id": 6, "name": "Exchange Square", "city": "Hong Kong", "country": "CN", "latitude": 22.283939361572266, "longitude": 114.15818786621094}
+{"store_id": 8, "name": "Telford Plaza", "city": "Kowloon", "country": "CN", "latitude": 22.3228702545166, "longitude": 114.21343994140625}
+`
+	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gateway := listing.NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+	if err := gateway.ProcessFile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHTTPServer("", gateway, cfg, zap.NewNop()).Handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doRequest(t *testing.T, method, url, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("error decoding %s %s response: %v", method, url, err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestStoreResource(t *testing.T) {
+	srv := setupServer(t)
+
+	var store listing.Store
+	if status := doRequest(t, "GET", srv.URL+"/stores/6", "", &store); status != http.StatusOK || store.Name != "Exchange Square" {
+		t.Errorf("expected store 6, got %d %+v", status, store)
+	}
+
+	var errRes ErrorResponse
+	if status := doRequest(t, "GET", srv.URL+"/stores/2", "", &errRes); status != http.StatusNotFound || errRes.Status != http.StatusNotFound || errRes.Error == "" {
+		t.Errorf("expected structured not found error, got %d %+v", status, errRes)
+	}
+
+	var stores StoresResponse
+	if status := doRequest(t, "GET", srv.URL+"/stores?ids=8,1,2,8", "", &stores); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if stores.Count != 2 || stores.Stores[0].Id != 8 || stores.Stores[1].Id != 1 || len(stores.Missing) != 1 || stores.Missing[0] != 2 {
+		t.Errorf("unexpected batch response %+v", stores)
+	}
+	if status := doRequest(t, "GET", srv.URL+"/stores?ids=1,x", "", &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for invalid id, got %d", status)
+	}
+
+	if status := doRequest(t, "POST", srv.URL+"/stores/13", `{"name": "Hong Kong Station", "latitude": 22.2844, "longitude": 114.1584}`, &store); status != http.StatusCreated || store.Id != 13 {
+		t.Errorf("expected store 13 to be created, got %d %+v", status, store)
+	}
+	if status := doRequest(t, "POST", srv.URL+"/stores/13", `{"name": "Hong Kong Station", "latitude": 22.2844, "longitude": 114.1584}`, &errRes); status != http.StatusConflict {
+		t.Errorf("expected conflict, got %d", status)
+	}
+	if status := doRequest(t, "PUT", srv.URL+"/stores/13", `{"store_id": 14, "name": "Hong Kong Station", "latitude": 22.2844, "longitude": 114.1584}`, &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for mismatched id, got %d", status)
+	}
+	if status := doRequest(t, "PUT", srv.URL+"/stores/13", `{"name": "Hong Kong Station", "latitude": 22.2844}`, &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for update without longitude, got %d", status)
+	}
+	if status := doRequest(t, "PATCH", srv.URL+"/stores/13", `{"longitude": null}`, &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for patch removing longitude, got %d", status)
+	}
+	if status := doRequest(t, "PATCH", srv.URL+"/stores/13", `{"latitude": 95}`, &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for invalid latitude, got %d", status)
+	}
+	if status := doRequest(t, "PATCH", srv.URL+"/stores/13", `{"city": "Central"}`, &store); status != http.StatusOK || store.City != "Central" || store.Name != "Hong Kong Station" {
+		t.Errorf("expected store 13 to be patched, got %d %+v", status, store)
+	}
+	if status := doRequest(t, "DELETE", srv.URL+"/stores/13", "", nil); status != http.StatusNoContent {
+		t.Errorf("expected no content, got %d", status)
+	}
+	if status := doRequest(t, "DELETE", srv.URL+"/stores/13", "", &errRes); status != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", status)
+	}
+}
+
+func TestCreateStoreWithoutLocation(t *testing.T) {
+	srv := setupServer(t)
+
+	var errRes ErrorResponse
+	if status := doRequest(t, "POST", srv.URL+"/stores/13", `{"name": "x"}`, &errRes); status != http.StatusBadRequest || errRes.Status != http.StatusBadRequest {
+		t.Errorf("expected bad request for store without location, got %d %+v", status, errRes)
+	}
+	if status := doRequest(t, "POST", srv.URL+"/stores/13", `{"name": "x", "latitude": 0, "longitude": null}`, &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for store without longitude, got %d", status)
+	}
+	if status := doRequest(t, "GET", srv.URL+"/stores/13", "", &errRes); status != http.StatusNotFound {
+		t.Errorf("expected store without location not to be added, got %d", status)
+	}
+	var store listing.Store
+	if status := doRequest(t, "POST", srv.URL+"/stores/13", `{"name": "Null Island", "latitude": 0, "longitude": 0}`, &store); status != http.StatusCreated || store.Latitude != 0 {
+		t.Errorf("expected store with explicit 0,0 location to be created, got %d %+v", status, store)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	gateway := &mockGateway{
+		getStoresForGeoPoint: func(ctx context.Context, lat, long float64, dist int) ([]*listing.StoreResult, error) {
+			bearing := 90.0
+			return []*listing.StoreResult{
+				{Store: &listing.Store{Id: 1, Name: "Plaza Hollywood", Latitude: lat, Longitude: long + 0.01}, DistanceKm: 1.03, Bearing: &bearing},
+			}, nil
+		},
+		getStoresForPostalCode: func(ctx context.Context, postalCode string, dist int) (*listing.GeoPoint, []*listing.StoreResult, error) {
+			if err := geocoder.ValidatePostalCode(postalCode); err != nil {
+				return nil, nil, err
+			}
+			switch postalCode {
+			case "92612":
+				return nil, nil, listing.ErrPostalSearchUnavailable
+			case "98101":
+				return nil, nil, fmt.Errorf("geocoding 98101: %w", geocoder.ErrOverQueryLimit)
+			case "10001":
+				return nil, nil, context.DeadlineExceeded
+			case "10002":
+				return nil, nil, errors.New("connection refused")
+			case "00000":
+				return nil, nil, fmt.Errorf("geocoding 00000: %w", geocoder.ErrZeroResults)
+			default:
+				return &listing.GeoPoint{Latitude: 22.34, Longitude: 114.2}, []*listing.StoreResult{}, nil
+			}
+		},
+	}
+	srv := httptest.NewServer(NewHTTPServer("", gateway, &config.Configuration{}, zap.NewNop()).Handler)
+	defer srv.Close()
+
+	var res SearchResponse
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"latitude": 22.34, "longitude": 114.2, "distance": 5}`, &res); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if res.Count != 1 || res.Origin.Latitude != 22.34 || res.Stores[0].Bearing != nil {
+		t.Errorf("unexpected search response %+v", res)
+	}
+
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"postalCode": "94105", "distance": 5}`, &res); status != http.StatusOK || res.Count != 0 || res.Stores == nil {
+		t.Errorf("expected ok with no stores, got %d %+v", status, res)
+	}
+
+	tests := []struct {
+		postalCode string
+		want       int
+	}{
+		{"92612", http.StatusServiceUnavailable},
+		{"98101", http.StatusBadGateway},
+		{"10001", http.StatusGatewayTimeout},
+		{"10002", http.StatusBadGateway},
+		{"92612|country:FR", http.StatusBadRequest},
+		{"00000", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		body := fmt.Sprintf(`{"postalCode": %q, "distance": 5}`, tt.postalCode)
+		if status := doRequest(t, "POST", srv.URL+"/search", body, nil); status != tt.want {
+			t.Errorf("postal code %s: expected %d, got %d", tt.postalCode, tt.want, status)
+		}
+	}
+}
+
+func TestSearchValidation(t *testing.T) {
+	srv := setupServer(t)
+
+	for _, body := range []string{
+		`{"latitude": 222, "longitude": 114.2, "distance": 5}`,
+		`{"latitude": -90.5, "longitude": 114.2, "distance": 5}`,
+		`{"latitude": 22.34, "longitude": 914, "distance": 5}`,
+		`{"latitude": 22.34, "longitude": -180.5, "distance": 5}`,
+		`{"latitude": 22.34, "longitude": 114.2, "distance": -5}`,
+		`{"latitude": 22.34, "longitude": 114.2}`,
+		`{"postalCode": "92612", "distance": -5}`,
+	} {
+		if status := doRequest(t, "POST", srv.URL+"/search", body, nil); status != http.StatusBadRequest {
+			t.Errorf("search %s: expected bad request, got %d", body, status)
+		}
+	}
+	var res SearchResponse
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"latitude": 90, "longitude": -180, "distance": 5}`, &res); status != http.StatusOK {
+		t.Errorf("expected search at range limits to be ok, got %d", status)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	srv := setupServer(t)
+
+	var search SearchResponse
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"latitude": 22.32, "longitude": 114.2, "distance": 10, "city": "hong kong", "name": "plaza"}`, &search); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if search.Count != 1 || search.Stores[0].Id != 1 {
+		t.Errorf("expected only store 1 to match, got %+v", search)
+	}
+
+	var found FindStoresResponse
+	if status := doRequest(t, "GET", srv.URL+"/stores?country=cn&name=plaza&limit=1", "", &found); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if found.Count != 1 || found.Total != 2 || found.Stores[0].Id != 1 {
+		t.Errorf("expected first of 2 plazas, got %+v", found)
+	}
+	var errRes ErrorResponse
+	if status := doRequest(t, "GET", srv.URL+"/stores?name=plaza", "", &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request without country or city, got %d", status)
+	}
+	if status := doRequest(t, "GET", srv.URL+"/stores?city=kowloon&limit=5000", "", &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for limit over max, got %d", status)
+	}
+}
+
+func TestSearchGeoJSON(t *testing.T) {
+	srv := setupServer(t)
+
+	search := func(accept string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("POST", srv.URL+"/search", strings.NewReader(`{"latitude": 22.34, "longitude": 114.2, "distance": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := search("application/json;q=0.5, application/geo+json")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != GEOJSON_CONTENT_TYPE {
+		t.Fatalf("expected geojson response, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	var fc FeatureCollection
+	if err := json.NewDecoder(res.Body).Decode(&fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 1 {
+		t.Fatalf("expected a feature collection of 1 store, got %+v", fc)
+	}
+	f := fc.Features[0]
+	if f.Type != "Feature" || f.Id != 1 || f.Geometry.Type != "Point" || f.Properties.Name != "Plaza Hollywood" {
+		t.Errorf("unexpected feature %+v", f)
+	}
+	if f.Geometry.Coordinates != [2]float64{114.20169067382812, 22.340700149536133} {
+		t.Errorf("expected longitude, latitude coordinates, got %v", f.Geometry.Coordinates)
+	}
+
+	res = search("application/geo+json;q=0, application/json")
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected json when geojson isn't acceptable, got %s", ct)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	srv := setupServer(t)
+
+	var res SearchResponse
+	if status := doRequest(t, "POST", srv.URL+"/nearest", `{"latitude": 22.34, "longitude": 114.2, "count": 2}`, &res); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if res.Count != 2 || len(res.Stores) != 2 || res.Stores[0].Id != 1 || res.Stores[1].Id != 8 {
+		t.Fatalf("expected stores 1 and 8, got %+v", res)
+	}
+	if res.Stores[0].DistanceKm > res.Stores[1].DistanceKm {
+		t.Errorf("expected stores nearest first, got %f, %f", res.Stores[0].DistanceKm, res.Stores[1].DistanceKm)
+	}
+
+	res = SearchResponse{}
+	if status := doRequest(t, "POST", srv.URL+"/nearest", `{"latitude": 22.34, "longitude": 114.2}`, &res); status != http.StatusOK || res.Count != 1 || res.Stores[0].Id != 1 {
+		t.Errorf("expected the nearest store when count is omitted, got %d %+v", status, res)
+	}
+	if status := doRequest(t, "POST", srv.URL+"/nearest", `{"latitude": 22.34, "longitude": 114.2, "count": -1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for negative count, got %d", status)
+	}
+}
+
+func TestTextSearch(t *testing.T) {
+	srv := setupServer(t)
+
+	var res TextSearchResponse
+	if status := doRequest(t, "GET", srv.URL+"/stores/search?q=exchange+sq", "", &res); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if res.Count != 1 || res.Stores[0].Id != 6 || res.Stores[0].Score <= 0 || res.Stores[0].DistanceKm != nil {
+		t.Errorf("expected exchange square, got %+v", res)
+	}
+	if status := doRequest(t, "GET", srv.URL+"/stores/search?q=plaza&latitude=22.32&longitude=114.21&limit=1", "", &res); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	if res.Count != 1 || res.Stores[0].Id != 8 || res.Stores[0].DistanceKm == nil {
+		t.Errorf("expected nearest plaza with its distance, got %+v", res)
+	}
+
+	var errRes ErrorResponse
+	if status := doRequest(t, "GET", srv.URL+"/stores/search?q=+", "", &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for empty query, got %d", status)
+	}
+	if status := doRequest(t, "GET", srv.URL+"/stores/search?q=plaza&latitude=22.32", "", &errRes); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for latitude without longitude, got %d", status)
+	}
+}
+
+func TestSearchDeadline(t *testing.T) {
+	gateway := &mockGateway{
+		getStoresForGeoPoint: func(ctx context.Context, lat, long float64, dist int) ([]*listing.StoreResult, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	srv := httptest.NewServer(NewHTTPServer("", gateway, &config.Configuration{RequestTimeout: "20ms"}, zap.NewNop()).Handler)
+	defer srv.Close()
+
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"latitude": 22.34, "longitude": 114.2, "distance": 5}`, nil); status != http.StatusGatewayTimeout {
+		t.Errorf("expected gateway timeout, got %d", status)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	srv := setupServerWithConfig(t, &config.Configuration{
+		AdminToken:         TEST_ADMIN_TOKEN,
+		CORSAllowedOrigins: []string{"https://example.com"},
+		MaxRequestBytes:    100,
+		MaxSearchDistance:  10,
+		MaxNearestCount:    2,
+	})
+
+	send := func(method, path, body string, header map[string]string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	store := `{"name": "Hong Kong Station", "latitude": 22.2844, "longitude": 114.1584}`
+	if res := send("POST", "/stores/13", store, nil); res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("expected unauthorized without token, got %d", res.StatusCode)
+	}
+	if res := send("POST", "/admin/reload", "", map[string]string{"Authorization": "Bearer wrong"}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized with wrong token, got %d", res.StatusCode)
+	}
+	if res := send("POST", "/stores/13", store, map[string]string{"Authorization": "Bearer s3cret"}); res.StatusCode != http.StatusCreated {
+		t.Errorf("expected store created with token, got %d", res.StatusCode)
+	}
+	if res := send("GET", "/stores/13", "", nil); res.StatusCode != http.StatusOK {
+		t.Errorf("expected reads to not need a token, got %d", res.StatusCode)
+	}
+
+	preflight := map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "PATCH"}
+	res := send("OPTIONS", "/stores/13", "", preflight)
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Access-Control-Allow-Origin") != "https://example.com" || !strings.Contains(res.Header.Get("Access-Control-Allow-Methods"), "PATCH") {
+		t.Errorf("expected preflight to be allowed, got %d %v", res.StatusCode, res.Header)
+	}
+	res = send("GET", "/stores/1", "", map[string]string{"Origin": "https://other.example.com"})
+	if res.StatusCode != http.StatusOK || res.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected other origin to not be allowed, got %d %v", res.StatusCode, res.Header)
+	}
+
+	if res := send("POST", "/search", `{"latitude": 22.34, "longitude": 114.2, "distance": 50}`, nil); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for distance over limit, got %d", res.StatusCode)
+	}
+	if res := send("POST", "/nearest", `{"latitude": 22.34, "longitude": 114.2, "count": 3}`, nil); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for count over limit, got %d", res.StatusCode)
+	}
+	big := `{"latitude": 22.34, "longitude": 114.2, "distance": 5, "postalCode": "` + strings.Repeat("9", 100) + `"}`
+	if res := send("POST", "/search", big, nil); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected request entity too large, got %d", res.StatusCode)
+	}
+}
+
+func TestAdminWithoutToken(t *testing.T) {
+	srv := setupServerWithConfig(t, &config.Configuration{})
+
+	var errRes ErrorResponse
+	store := `{"name": "Hong Kong Station", "latitude": 22.2844, "longitude": 114.1584}`
+	if status := doRequest(t, "POST", srv.URL+"/stores/13", store, &errRes); status != http.StatusForbidden || errRes.Status != http.StatusForbidden {
+		t.Errorf("expected writes to be forbidden without a configured token, got %d %+v", status, errRes)
+	}
+	if status := doRequest(t, "DELETE", srv.URL+"/stores/1", "", &errRes); status != http.StatusForbidden {
+		t.Errorf("expected deletes to be forbidden without a configured token, got %d", status)
+	}
+	if status := doRequest(t, "POST", srv.URL+"/admin/reload", "", &errRes); status != http.StatusForbidden {
+		t.Errorf("expected reloads to be forbidden without a configured token, got %d", status)
+	}
+	var s listing.Store
+	if status := doRequest(t, "GET", srv.URL+"/stores/1", "", &s); status != http.StatusOK || s.Id != 1 {
+		t.Errorf("expected reads to stay open, got %d %+v", status, s)
+	}
+}
+
+func TestReload(t *testing.T) {
+	srv := setupServer(t)
+
+	req, err := http.NewRequest("POST", srv.URL+"/admin/reload", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+TEST_ADMIN_TOKEN)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected accepted json response, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	var stats map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["count"] != 3.0 || stats["ready"] != true || stats["version"] == nil {
+		t.Errorf("unexpected reload stats %v", stats)
+	}
+	d, ok := stats["loadDuration"].(string)
+	if !ok {
+		t.Fatalf("expected load duration string, got %v", stats["loadDuration"])
+	}
+	if _, err := time.ParseDuration(d); err != nil {
+		t.Errorf("expected load duration to parse, got %q: %v", d, err)
+	}
+	if _, ok := stats["loadError"]; ok {
+		t.Errorf("expected no load error, got %v", stats["loadError"])
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stores.ndjson")
+	gateway := listing.NewJasonGateway(&config.Configuration{DataFiles: []string{filePath}}, nil, nil, zap.NewNop())
+	srv := httptest.NewServer(NewHTTPServer("", gateway, &config.Configuration{}, zap.NewNop()).Handler)
+	defer srv.Close()
+
+	if status := doRequest(t, "GET", srv.URL+"/livez", "", nil); status != http.StatusOK {
+		t.Errorf("expected live, got %d", status)
+	}
+
+	var readiness listing.Readiness
+	if status := doRequest(t, "GET", srv.URL+"/readyz", "", &readiness); status != http.StatusServiceUnavailable || readiness.Ready || readiness.Checks["data"] != listing.CHECK_NOT_LOADED {
+		t.Errorf("expected not ready before loading, got %d %+v", status, readiness)
+	}
+	res, err := http.Post(srv.URL+"/search", "application/json", strings.NewReader(`{"latitude": 22.34, "longitude": 114.2, "distance": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+		t.Errorf("expected search before loading to be unavailable with retry after, got %d %q", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+
+	if err := os.WriteFile(filePath, []byte(`{"store_id": 1, "name": "Plaza Hollywood", "latitude": 22.3407, "longitude": 114.2016}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.ProcessFile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	readiness = listing.Readiness{}
+	if status := doRequest(t, "GET", srv.URL+"/readyz", "", &readiness); status != http.StatusOK || !readiness.Ready {
+		t.Errorf("expected ready after loading, got %d %+v", status, readiness)
+	}
+	var search SearchResponse
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"latitude": 22.34, "longitude": 114.2, "distance": 5}`, &search); status != http.StatusOK || search.Count != 1 {
+		t.Errorf("expected search to find store after loading, got %d %+v", status, search)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := setupServer(t)
+
+	var search SearchResponse
+	if status := doRequest(t, "POST", srv.URL+"/search", `{"latitude": 22.34, "longitude": 114.2, "distance": 5}`, &search); status != http.StatusOK {
+		t.Fatalf("expected ok, got %d", status)
+	}
+	doRequest(t, "GET", srv.URL+"/stores/2", "", nil)
+	doRequest(t, "GET", srv.URL+"/nope", "", nil)
+	doRequest(t, "GET", srv.URL+"/search", "", nil)
+
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`starbucks_http_requests_total{method="POST",route="/search",status="200"}`,
+		`starbucks_http_requests_total{method="GET",route="/stores/{id:[0-9]+}",status="404"}`,
+		`starbucks_http_request_duration_seconds_bucket{method="POST",route="/search",status="200",le="+Inf"}`,
+		`starbucks_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`starbucks_http_requests_total{method="GET",route="unmatched",status="405"}`,
+		`starbucks_search_stores_examined_count{search="geopoint"}`,
+		`starbucks_search_stores_returned_count{search="geopoint"}`,
+		"starbucks_stores 3\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+}
+
+func TestSearchTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	srv := setupServer(t)
+
+	req, err := http.NewRequest("POST", srv.URL+"/search", strings.NewReader(`{"latitude": 22.34, "longitude": 114.2, "distance": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	if _, ok := spans["ProcessFile"]; !ok {
+		t.Error("expected a span for loading store data")
+	}
+	root, ok := spans["POST /search"]
+	if !ok {
+		t.Fatalf("expected a span for the search request, got %v", spans)
+	}
+	if root.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || root.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected search span to continue caller's trace, got %v parent %v", root.SpanContext(), root.Parent())
+	}
+	lookup, ok := spans["SpatialLookup"]
+	if !ok || lookup.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("expected spatial lookup span to be a child of the search span")
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	gateway := listing.NewJasonGateway(&config.Configuration{}, nil, nil, zap.NewNop())
+	srv := httptest.NewServer(NewHTTPServer("", gateway, &config.Configuration{}, zap.New(core)).Handler)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/stores/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "req-42")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if id := res.Header.Get("X-Request-ID"); id != "req-42" {
+		t.Errorf("expected request id to be echoed, got %q", id)
+	}
+
+	// gateway logs through the request scoped logger
+	if n := logs.FilterMessage("store doesn't exist").FilterField(zap.String("requestId", "req-42")).Len(); n != 1 {
+		t.Errorf("expected gateway log tagged with request id, got %d", n)
+	}
+	access := logs.FilterMessage("request").FilterField(zap.String("requestId", "req-42")).All()
+	if len(access) != 1 {
+		t.Fatalf("expected one access log line, got %d", len(access))
+	}
+	fields := access[0].ContextMap()
+	if fields["method"] != "GET" || fields["route"] != "/stores/{id:[0-9]+}" || fields["status"] != int64(http.StatusNotFound) || fields["bytes"].(int64) == 0 {
+		t.Errorf("unexpected access log fields %v", fields)
+	}
+
+	res, err = http.Get(srv.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if id := res.Header.Get("X-Request-ID"); len(id) != 32 {
+		t.Errorf("expected generated request id, got %q", id)
+	}
+}
+
+func TestUnmatchedRequestLogging(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	gateway := listing.NewJasonGateway(&config.Configuration{}, nil, nil, zap.NewNop())
+	srv := httptest.NewServer(NewHTTPServer("", gateway, &config.Configuration{}, zap.New(core)).Handler)
+	defer srv.Close()
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/nope", http.StatusNotFound},
+		{"GET", "/search", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Fatalf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, res.StatusCode)
+		}
+		id := res.Header.Get("X-Request-ID")
+		if len(id) != 32 {
+			t.Errorf("%s %s: expected generated request id, got %q", tt.method, tt.path, id)
+		}
+		access := logs.FilterMessage("request").FilterField(zap.String("requestId", id)).All()
+		if len(access) != 1 {
+			t.Fatalf("%s %s: expected one access log line, got %d", tt.method, tt.path, len(access))
+		}
+		if fields := access[0].ContextMap(); fields["route"] != UNMATCHED_ROUTE || fields["path"] != tt.path || fields["status"] != int64(tt.want) {
+			t.Errorf("%s %s: unexpected access log fields %v", tt.method, tt.path, fields)
+		}
+	}
+}
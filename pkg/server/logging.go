@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+	"unicode"
+
+	"github.com/hankgalt/starbucks/pkg/constants"
+	"github.com/hankgalt/starbucks/pkg/logging"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// logRequests tags each request with an id, accepted from the X-Request-ID header
+// or generated, puts a logger carrying it into the request context and writes
+// one access log line once the request is served
+func (s *httpServer) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(constants.REQUEST_ID_HEADER)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(constants.REQUEST_ID_HEADER, id)
+
+		logger := s.logger.With(zap.String("requestId", id))
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.With(zap.String("traceId", sc.TraceID().String()))
+		}
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(logging.WithLogger(r.Context(), logger)))
+
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		logger.Info("request",
+			zap.String("method", r.Method),
+			zap.String("route", routeTemplate(r)),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", sr.status),
+			zap.Int("bytes", sr.bytes),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remoteAddr", r.RemoteAddr),
+		)
+	})
+}
+
+// requestLogger returns the request scoped logger
+func (s *httpServer) requestLogger(r *http.Request) *zap.Logger {
+	return logging.FromContext(r.Context(), s.logger)
+}
+
+// validRequestId accepts client request ids of printable ascii up to max length
+func validRequestId(id string) bool {
+	if id == "" || len(id) > constants.MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if c > unicode.MaxASCII || !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
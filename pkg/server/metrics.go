@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hankgalt/starbucks/pkg/listing"
+	"github.com/hankgalt/starbucks/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler serves process wide metrics along with the stats of given gateway
+func metricsHandler(gateway listing.Gateway) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newGatewayCollector(gateway))
+	return promhttp.HandlerFor(prometheus.Gatherers{metrics.Registry, reg}, promhttp.HandlerOpts{})
+}
+
+// statusRecorder captures the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// instrument counts and times requests by route template, method and status
+func (s *httpServer) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		route := routeTemplate(r)
+		status := strconv.Itoa(sr.status)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// UNMATCHED_ROUTE is the route of requests no route matched, unknown paths aren't used
+// as routes so they can't grow span names or metric labels without bound
+const UNMATCHED_ROUTE = "unmatched"
+
+type routeKey struct{}
+
+// trackRoute gives middleware wrapping the router a place to find the route that matched,
+// the router's own request context isn't passed back out
+func trackRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var route string
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+	})
+}
+
+// recordRoute notes the path template of the matched route for trackRoute
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*route = routeTemplate(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeTemplate returns the path template of the matched route, or UNMATCHED_ROUTE when none matched
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	if route, ok := r.Context().Value(routeKey{}).(*string); ok && *route != "" {
+		return *route
+	}
+	return UNMATCHED_ROUTE
+}
+
+// gatewayCollector reports gateway stats when scraped
+type gatewayCollector struct {
+	gateway     listing.Gateway
+	stores      *prometheus.Desc
+	cells       *prometheus.Desc
+	version     *prometheus.Desc
+	ready       *prometheus.Desc
+	loadedAt    *prometheus.Desc
+	cacheHits   *prometheus.Desc
+	cacheMisses *prometheus.Desc
+}
+
+func newGatewayCollector(gateway listing.Gateway) *gatewayCollector {
+	name := func(n string) string {
+		return prometheus.BuildFQName(metrics.NAMESPACE, "", n)
+	}
+	return &gatewayCollector{
+		gateway:     gateway,
+		stores:      prometheus.NewDesc(name("stores"), "Number of stores in the current dataset.", nil, nil),
+		cells:       prometheus.NewDesc(name("index_cells"), "Number of populated spatial index cells.", nil, nil),
+		version:     prometheus.NewDesc(name("dataset_version"), "Version of the current dataset, incremented on every load.", nil, nil),
+		ready:       prometheus.NewDesc(name("ready"), "Whether store data is loaded.", nil, nil),
+		loadedAt:    prometheus.NewDesc(name("dataset_loaded_timestamp_seconds"), "Time the current dataset was loaded.", nil, nil),
+		cacheHits:   prometheus.NewDesc(name("geocoder_cache_hits_total"), "Number of geocode cache hits.", nil, nil),
+		cacheMisses: prometheus.NewDesc(name("geocoder_cache_misses_total"), "Number of geocode cache misses.", nil, nil),
+	}
+}
+
+func (gc *gatewayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gc.stores
+	ch <- gc.cells
+	ch <- gc.version
+	ch <- gc.ready
+	ch <- gc.loadedAt
+	ch <- gc.cacheHits
+	ch <- gc.cacheMisses
+}
+
+func (gc *gatewayCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := gc.gateway.GetStoreStats()
+	ready := 0.0
+	if stats.Ready {
+		ready = 1
+	}
+	ch <- prometheus.MustNewConstMetric(gc.stores, prometheus.GaugeValue, float64(stats.Count))
+	ch <- prometheus.MustNewConstMetric(gc.cells, prometheus.GaugeValue, float64(stats.CellCount))
+	ch <- prometheus.MustNewConstMetric(gc.version, prometheus.GaugeValue, float64(stats.Version))
+	ch <- prometheus.MustNewConstMetric(gc.ready, prometheus.GaugeValue, ready)
+	if !stats.LoadedAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(gc.loadedAt, prometheus.GaugeValue, float64(stats.LoadedAt.UnixNano())/1e9)
+	}
+	ch <- prometheus.MustNewConstMetric(gc.cacheHits, prometheus.CounterValue, float64(stats.CacheHits))
+	ch <- prometheus.MustNewConstMetric(gc.cacheMisses, prometheus.CounterValue, float64(stats.CacheMisses))
+}
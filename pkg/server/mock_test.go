@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+
+	"github.com/hankgalt/starbucks/pkg/listing"
+)
+
+// mockGateway stubs the gateway methods a test sets, calling any other method panics.
+// Store data is reported loaded unless notReady is set.
+type mockGateway struct {
+	listing.Gateway
+	notReady               bool
+	getStore               func(ctx context.Context, storeId uint32) (*listing.Store, error)
+	getStoresForGeoPoint   func(ctx context.Context, lat, long float64, dist int) ([]*listing.StoreResult, error)
+	getStoresForPostalCode func(ctx context.Context, postalCode string, dist int) (*listing.GeoPoint, []*listing.StoreResult, error)
+}
+
+func (m *mockGateway) GetStoreStats() listing.GatewayStats {
+	return listing.GatewayStats{Ready: !m.notReady}
+}
+
+func (m *mockGateway) GetStore(ctx context.Context, storeId uint32) (*listing.Store, error) {
+	return m.getStore(ctx, storeId)
+}
+
+func (m *mockGateway) GetStoresForGeoPoint(ctx context.Context, lat, long float64, dist int, filter *listing.StoreFilter) ([]*listing.StoreResult, error) {
+	return m.getStoresForGeoPoint(ctx, lat, long, dist)
+}
+
+func (m *mockGateway) GetStoresForPostalCode(ctx context.Context, postalCode string, dist int, filter *listing.StoreFilter) (*listing.GeoPoint, []*listing.StoreResult, error) {
+	return m.getStoresForPostalCode(ctx, postalCode, dist)
+}
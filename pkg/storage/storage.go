@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hankgalt/starbucks/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	SNAPSHOT_FILE = "stores.snapshot"
+	WAL_FILE      = "stores.wal"
+)
+
+// DEFAULT_COMPACT_THRESHOLD is the number of log records after which
+// the log is folded into a new snapshot
+const DEFAULT_COMPACT_THRESHOLD = 1000
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+type record struct {
+	Op    string          `json:"op,omitempty"`
+	Id    uint32          `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// FileStorage is an embedded key value store of json values keyed by id,
+// kept in a directory as a snapshot file and a write ahead log.
+// Every write is appended to the log and synced to disk before it's
+// acknowledged, the log is replayed over the snapshot on open and
+// periodically compacted into a new snapshot. Ids written through Put
+// and Delete are tracked, so rebasing on freshly imported values keeps them.
+type FileStorage struct {
+	mu        sync.Mutex
+	dir       string
+	wal       *os.File
+	walCount  int
+	threshold int
+	values    map[uint32]json.RawMessage
+	written   map[uint32]bool
+	logger    *zap.Logger
+}
+
+// NewFileStorage opens the storage in given directory, creating it if needed
+func NewFileStorage(dir string, logger *zap.Logger) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.WrapError(err, "error creating storage directory %s", dir)
+	}
+	fs := &FileStorage{
+		dir:       dir,
+		threshold: DEFAULT_COMPACT_THRESHOLD,
+		values:    map[uint32]json.RawMessage{},
+		written:   map[uint32]bool{},
+		logger:    logger,
+	}
+	if err := fs.readSnapshot(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, WAL_FILE), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.WrapError(err, "error opening storage log")
+	}
+	fs.wal = wal
+	if err := fs.replay(); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	logger.Info("opened storage", zap.String("dir", dir), zap.Int("count", len(fs.values)), zap.Int("logRecords", fs.walCount))
+	return fs, nil
+}
+
+// Len returns the number of stored values
+func (fs *FileStorage) Len() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return len(fs.values)
+}
+
+// ForEach calls fn for every stored value in id order, stopping at the first error
+func (fs *FileStorage) ForEach(fn func(id uint32, value []byte) error) error {
+	fs.mu.Lock()
+	ids := make([]uint32, 0, len(fs.values))
+	for id := range fs.values {
+		ids = append(ids, id)
+	}
+	values := make(map[uint32]json.RawMessage, len(fs.values))
+	for k, v := range fs.values {
+		values[k] = v
+	}
+	fs.mu.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := fn(id, values[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put durably stores value for id
+func (fs *FileStorage) Put(id uint32, value []byte) error {
+	if !json.Valid(value) {
+		return fmt.Errorf("invalid json value for id %d", id)
+	}
+	v := make(json.RawMessage, len(value))
+	copy(v, value)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.append(&record{Op: opPut, Id: id, Value: v}); err != nil {
+		return err
+	}
+	fs.values[id] = v
+	fs.written[id] = true
+	return fs.maybeCompact()
+}
+
+// Delete durably removes the value for id
+func (fs *FileStorage) Delete(id uint32) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.append(&record{Op: opDelete, Id: id}); err != nil {
+		return err
+	}
+	delete(fs.values, id)
+	fs.written[id] = true
+	return fs.maybeCompact()
+}
+
+// ReplaceAll atomically replaces all stored values with given ones, forgetting earlier writes
+func (fs *FileStorage) ReplaceAll(values map[uint32][]byte) error {
+	nv, err := validValues(values)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.replace(nv, map[uint32]bool{})
+}
+
+// Rebase atomically replaces stored values with given ones, except for the ids
+// written through Put and Delete, which keep their written value or stay deleted
+func (fs *FileStorage) Rebase(values map[uint32][]byte) error {
+	nv, err := validValues(values)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for id := range fs.written {
+		if v, ok := fs.values[id]; ok {
+			nv[id] = v
+		} else {
+			delete(nv, id)
+		}
+	}
+	return fs.replace(nv, fs.written)
+}
+
+// ForEachWrite calls fn for every id written through Put and Delete since values were
+// last replaced, in id order, with a nil value for deleted ids. Stops at the first error.
+func (fs *FileStorage) ForEachWrite(fn func(id uint32, value []byte) error) error {
+	fs.mu.Lock()
+	ids := make([]uint32, 0, len(fs.written))
+	values := make(map[uint32]json.RawMessage, len(fs.written))
+	for id := range fs.written {
+		ids = append(ids, id)
+		values[id] = fs.values[id]
+	}
+	fs.mu.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := fn(id, values[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact folds the write ahead log into a new snapshot
+func (fs *FileStorage) Compact() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.writeSnapshot(fs.values, fs.written)
+}
+
+// replace swaps in given values and written ids. Callers must hold the lock.
+func (fs *FileStorage) replace(values map[uint32]json.RawMessage, written map[uint32]bool) error {
+	// fold the log into the current snapshot first, so no record is left
+	// to be replayed over the new values if writing them is interrupted
+	if fs.walCount > 0 {
+		if err := fs.writeSnapshot(fs.values, fs.written); err != nil {
+			return err
+		}
+	}
+	if err := fs.writeSnapshot(values, written); err != nil {
+		return err
+	}
+	fs.values, fs.written = values, written
+	return nil
+}
+
+func validValues(values map[uint32][]byte) (map[uint32]json.RawMessage, error) {
+	nv := make(map[uint32]json.RawMessage, len(values))
+	for k, v := range values {
+		if !json.Valid(v) {
+			return nil, fmt.Errorf("invalid json value for id %d", k)
+		}
+		nv[k] = v
+	}
+	return nv, nil
+}
+
+// Close syncs and closes the write ahead log
+func (fs *FileStorage) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.wal == nil {
+		return nil
+	}
+	err := fs.wal.Sync()
+	if cerr := fs.wal.Close(); err == nil {
+		err = cerr
+	}
+	fs.wal = nil
+	if err != nil {
+		return errors.WrapError(err, "error closing storage log")
+	}
+	return nil
+}
+
+// append writes record to the log and syncs it. Callers must hold the lock.
+func (fs *FileStorage) append(r *record) error {
+	if fs.wal == nil {
+		return fmt.Errorf("storage is closed")
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.WrapError(err, "error encoding storage log record")
+	}
+	if _, err = fs.wal.Write(append(data, '\n')); err != nil {
+		return errors.WrapError(err, "error writing storage log")
+	}
+	if err = fs.wal.Sync(); err != nil {
+		return errors.WrapError(err, "error syncing storage log")
+	}
+	fs.walCount++
+	return nil
+}
+
+// maybeCompact compacts the log once it reaches the threshold. Callers must hold the lock.
+// The write is already durable in the log, a failed compaction is only logged.
+func (fs *FileStorage) maybeCompact() error {
+	if fs.walCount < fs.threshold {
+		return nil
+	}
+	if err := fs.writeSnapshot(fs.values, fs.written); err != nil {
+		fs.logger.Error("error compacting storage log", zap.Error(err), zap.String("dir", fs.dir))
+	}
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot file with given values
+// and truncates the log it supersedes. Written ids are kept as put records,
+// or delete records once deleted. Callers must hold the lock.
+// Records left in the log by a crash before truncation must already be
+// reflected in values, so replaying them over the new snapshot is a no-op.
+func (fs *FileStorage) writeSnapshot(values map[uint32]json.RawMessage, written map[uint32]bool) error {
+	if fs.wal == nil {
+		return fmt.Errorf("storage is closed")
+	}
+	filePath := filepath.Join(fs.dir, SNAPSHOT_FILE)
+	tmp, err := os.CreateTemp(fs.dir, SNAPSHOT_FILE+".*")
+	if err != nil {
+		return errors.WrapError(err, "error creating storage snapshot")
+	}
+	defer os.Remove(tmp.Name())
+
+	ids := make([]uint32, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	for id := range written {
+		if _, ok := values[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, id := range ids {
+		r := &record{Id: id, Value: values[id]}
+		if written[id] {
+			r.Op = opPut
+			if r.Value == nil {
+				r.Op = opDelete
+			}
+		}
+		if err = enc.Encode(r); err != nil {
+			tmp.Close()
+			return errors.WrapError(err, "error writing storage snapshot")
+		}
+	}
+	if err = w.Flush(); err != nil {
+		tmp.Close()
+		return errors.WrapError(err, "error writing storage snapshot")
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.WrapError(err, "error syncing storage snapshot")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.WrapError(err, "error closing storage snapshot")
+	}
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		return errors.WrapError(err, "error replacing storage snapshot")
+	}
+	if err = syncDir(fs.dir); err != nil {
+		return err
+	}
+
+	if err = fs.wal.Truncate(0); err != nil {
+		return errors.WrapError(err, "error truncating storage log")
+	}
+	if err = fs.wal.Sync(); err != nil {
+		return errors.WrapError(err, "error syncing storage log")
+	}
+	fs.walCount = 0
+	fs.logger.Info("wrote storage snapshot", zap.Int("count", len(values)), zap.String("dir", fs.dir))
+	return nil
+}
+
+func (fs *FileStorage) readSnapshot() error {
+	f, err := os.Open(filepath.Join(fs.dir, SNAPSHOT_FILE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WrapError(err, "error opening storage snapshot")
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var r record
+		if err := dec.Decode(&r); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.WrapError(err, "error decoding storage snapshot")
+		}
+		switch r.Op {
+		case "":
+			fs.values[r.Id] = r.Value
+		case opPut:
+			fs.values[r.Id] = r.Value
+			fs.written[r.Id] = true
+		case opDelete:
+			fs.written[r.Id] = true
+		default:
+			return fmt.Errorf("unknown storage snapshot operation %q for id %d", r.Op, r.Id)
+		}
+	}
+}
+
+// replay applies log records over the snapshot values. A torn record at the
+// end of the log, left by a crash mid write, was never acknowledged and is
+// truncated away, anything else that doesn't decode is an error.
+func (fs *FileStorage) replay() error {
+	if _, err := fs.wal.Seek(0, io.SeekStart); err != nil {
+		return errors.WrapError(err, "error reading storage log")
+	}
+	r := bufio.NewReader(fs.wal)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(data)) > 0 {
+				fs.logger.Info("truncating partial storage log record", zap.Int("line", line), zap.Int64("offset", offset))
+				if err := fs.wal.Truncate(offset); err != nil {
+					return errors.WrapError(err, "error truncating storage log")
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return errors.WrapError(err, "error reading storage log")
+		}
+		offset += int64(len(data))
+
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return errors.WrapError(err, "error decoding storage log line %d", line)
+		}
+		switch rec.Op {
+		case opPut:
+			fs.values[rec.Id] = rec.Value
+		case opDelete:
+			delete(fs.values, rec.Id)
+		default:
+			return fmt.Errorf("unknown storage log operation %q on line %d", rec.Op, line)
+		}
+		fs.written[rec.Id] = true
+		fs.walCount++
+	}
+}
+
+// syncDir syncs directory entries so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.WrapError(err, "error opening storage directory")
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil {
+		return errors.WrapError(err, "error syncing storage directory")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func openStorage(t *testing.T, dir string) *FileStorage {
+	t.Helper()
+	fs, err := NewFileStorage(dir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error opening storage: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func contents(t *testing.T, fs *FileStorage) map[uint32]string {
+	t.Helper()
+	values := map[uint32]string{}
+	if err := fs.ForEach(func(id uint32, value []byte) error {
+		values[id] = string(value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	dir := t.TempDir()
+	fs := openStorage(t, dir)
+	for id, v := range map[uint32]string{1: `{"name":"a"}`, 6: `{"name":"b"}`, 8: `{"name":"c"}`} {
+		if err := fs.Put(id, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Put(6, []byte(`{"name":"b2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put(9, []byte(`{"name":`)); err == nil {
+		t.Error("expected invalid json value to be rejected")
+	}
+	// no Close, acknowledged writes are already on disk
+
+	reopened := openStorage(t, dir)
+	got := contents(t, reopened)
+	if len(got) != 2 || got[1] != `{"name":"a"}` || got[6] != `{"name":"b2"}` {
+		t.Errorf("unexpected values after reopening %v", got)
+	}
+}
+
+func TestFileStorageTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	fs := openStorage(t, dir)
+	if err := fs.Put(1, []byte(`{"name":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	// crash in the middle of appending a record
+	f, err := os.OpenFile(filepath.Join(dir, WAL_FILE), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","id":2,"val`)
+	f.Close()
+
+	fs = openStorage(t, dir)
+	if got := contents(t, fs); len(got) != 1 || got[1] != `{"name":"a"}` {
+		t.Errorf("expected torn record to be dropped, got %v", got)
+	}
+	if err := fs.Put(2, []byte(`{"name":"b"}`)); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	if got := contents(t, openStorage(t, dir)); len(got) != 2 || got[2] != `{"name":"b"}` {
+		t.Errorf("expected writes after truncation to replay, got %v", got)
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	dir := t.TempDir()
+	fs := openStorage(t, dir)
+	fs.threshold = 3
+	for i := uint32(1); i <= 4; i++ {
+		if err := fs.Put(i, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fs.walCount != 1 {
+		t.Errorf("expected log to be compacted, got %d records", fs.walCount)
+	}
+	if got := contents(t, openStorage(t, dir)); len(got) != 4 {
+		t.Errorf("expected 4 values after compaction, got %v", got)
+	}
+
+	if err := fs.ReplaceAll(map[uint32][]byte{7: []byte(`{"name":"g"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if fs.walCount != 0 {
+		t.Errorf("expected empty log after replacing values, got %d records", fs.walCount)
+	}
+	if got := contents(t, openStorage(t, dir)); len(got) != 1 || got[7] != `{"name":"g"}` {
+		t.Errorf("unexpected values after replacing %v", got)
+	}
+}
+
+func TestFileStorageRebase(t *testing.T) {
+	dir := t.TempDir()
+	fs := openStorage(t, dir)
+	if err := fs.ReplaceAll(map[uint32][]byte{1: []byte(`{"name":"a"}`), 6: []byte(`{"name":"b"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put(1, []byte(`{"name":"a2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// writes survive compaction and reopening, and stay applied over rebased values
+	fs = openStorage(t, dir)
+	if err := fs.Rebase(map[uint32][]byte{1: []byte(`{"name":"a"}`), 6: []byte(`{"name":"b"}`), 8: []byte(`{"name":"c"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(t, openStorage(t, dir)); len(got) != 2 || got[1] != `{"name":"a2"}` || got[8] != `{"name":"c"}` {
+		t.Errorf("expected writes applied over rebased values, got %v", got)
+	}
+	writes := map[uint32][]byte{}
+	if err := fs.ForEachWrite(func(id uint32, value []byte) error {
+		writes[id] = value
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 2 || string(writes[1]) != `{"name":"a2"}` || writes[6] != nil {
+		t.Errorf("expected put 1 and deleted 6 as writes, got %v", writes)
+	}
+
+	if err := fs.ReplaceAll(map[uint32][]byte{6: []byte(`{"name":"b"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(t, openStorage(t, dir)); len(got) != 1 || got[6] != `{"name":"b"}` {
+		t.Errorf("expected replacing values to drop writes, got %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/hankgalt/starbucks/pkg/errors"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// idFields are the OTLP JSON fields holding trace and span ids,
+// encoded as hex strings unlike other bytes fields
+var idFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+// fileClient is an otlptrace client appending spans to a file in the OTLP JSON
+// file format, one ExportTraceServiceRequest per line, as read by the collector's
+// otlpjsonfile receiver
+type fileClient struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop closes the file once pending uploads are written
+func (c *fileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.file.Close(); err != nil {
+		return errors.WrapError(err, "error closing trace file")
+	}
+	return nil
+}
+
+func (c *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	data, err := marshalOTLPJSON(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err = c.file.Write(append(data, '\n')); err != nil {
+		return errors.WrapError(err, "error writing trace file")
+	}
+	return nil
+}
+
+// marshalOTLPJSON encodes msg as OTLP JSON, the protobuf json mapping with
+// enums as numbers and trace and span ids hex encoded
+func marshalOTLPJSON(msg proto.Message) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(msg)
+	if err != nil {
+		return nil, errors.WrapError(err, "error encoding spans")
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return nil, errors.WrapError(err, "error encoding spans")
+	}
+	if err = hexIDs(v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// hexIDs re-encodes the base64 trace and span ids in a decoded json value as hex
+func hexIDs(v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, f := range v {
+			s, ok := f.(string)
+			if !idFields[k] || !ok {
+				if err := hexIDs(f); err != nil {
+					return err
+				}
+				continue
+			}
+			id, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return errors.WrapError(err, "error encoding %s", k)
+			}
+			v[k] = hex.EncodeToString(id)
+		}
+	case []interface{}:
+		for _, f := range v {
+			if err := hexIDs(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
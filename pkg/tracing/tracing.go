@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"github.com/hankgalt/starbucks/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+)
+
+const SERVICE_NAME = "store-server"
+
+const (
+	STDOUT_EXPORTER = "stdout"
+	FILE_EXPORTER   = "file"
+	OTLP_EXPORTER   = "otlp"
+)
+
+// Setup installs the global tracer provider for configured exporter and the
+// W3C trace context propagator. The returned shutdown flushes pending spans
+// and releases the exporter. Spans are dropped when no exporter is configured.
+// The file exporter appends spans to the trace file in the OTLP JSON file format.
+func Setup(ctx context.Context, cfg *config.Configuration, logger *zap.Logger) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TraceExporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case STDOUT_EXPORTER:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case FILE_EXPORTER:
+		if cfg.TraceFile == "" {
+			return nil, fmt.Errorf("missing trace file for %s trace exporter", FILE_EXPORTER)
+		}
+		file, ferr := os.OpenFile(cfg.TraceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if ferr != nil {
+			return nil, errors.WrapError(ferr, "error opening trace file %s", cfg.TraceFile)
+		}
+		exporter, err = otlptrace.New(ctx, &fileClient{file: file})
+		if err != nil {
+			file.Close()
+		}
+	case OTLP_EXPORTER:
+		opts := []otlptracehttp.Option{}
+		if cfg.TraceEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.TraceEndpoint), otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", cfg.TraceExporter)
+	}
+	if err != nil {
+		return nil, errors.WrapError(err, "error creating %s trace exporter", cfg.TraceExporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", SERVICE_NAME))),
+	)
+	otel.SetTracerProvider(tp)
+	logger.Info("exporting traces", zap.String("exporter", cfg.TraceExporter))
+
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hankgalt/starbucks/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+)
+
+func TestSetupFileExporter(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Setup(context.Background(), &config.Configuration{TraceExporter: FILE_EXPORTER, TraceFile: filePath}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "ProcessFile")
+	span.End()
+	if err = shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one OTLP JSON export request per line
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceId string `json:"traceId"`
+					SpanId  string `json:"spanId"`
+					Name    string `json:"name"`
+					Kind    int    `json:"kind"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if err := json.Unmarshal([]byte(lines[0]), &req); err != nil || len(lines) != 1 {
+		t.Fatalf("expected one OTLP JSON line, got %s, %v", data, err)
+	}
+	rs := req.ResourceSpans[0]
+	span0 := rs.ScopeSpans[0].Spans[0]
+	if span0.Name != "ProcessFile" || len(span0.TraceId) != 32 || len(span0.SpanId) != 16 || span0.Kind != 1 {
+		t.Errorf("expected hex encoded ids and internal span kind, got %+v", span0)
+	}
+	if _, err := hex.DecodeString(span0.TraceId); err != nil {
+		t.Errorf("expected hex trace id, got %s", span0.TraceId)
+	}
+	if !strings.Contains(lines[0], `"stringValue":"`+SERVICE_NAME+`"`) {
+		t.Errorf("expected service name resource attribute, got %+v", rs.Resource)
+	}
+
+	if _, err = Setup(context.Background(), &config.Configuration{TraceExporter: FILE_EXPORTER}, zap.NewNop()); err == nil {
+		t.Error("expected error for file exporter without trace file")
+	}
+	if _, err = Setup(context.Background(), &config.Configuration{TraceExporter: "jaeger"}, zap.NewNop()); err == nil {
+		t.Error("expected error for unknown exporter")
+	}
+}